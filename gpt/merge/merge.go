@@ -2,6 +2,7 @@ package merge
 
 import (
 	"reflect"
+	"sort"
 )
 
 const (
@@ -16,20 +17,40 @@ const (
 	ReplaceByIndexPreferMaxRec   = "replace_by_index_prefer_max_rec"
 )
 
-func MergeMapStructure(m1, m2 map[string]interface{}, mergeType string) map[string]interface{} {
+// Option 配置 MergeMapStructure
+type Option func(*options)
+
+type options struct {
+	deterministicOrder bool
+}
+
+// WithDeterministicOrder 按键的升序遍历m2，而不是map的随机顺序，使每次合并的过程都相同
+func WithDeterministicOrder() Option {
+	return func(o *options) {
+		o.deterministicOrder = true
+	}
+}
+
+func MergeMapStructure(m1, m2 map[string]interface{}, mergeType string, opts ...Option) map[string]interface{} {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	result := make(map[string]interface{})
 
 	for k, v := range m1 {
 		result[k] = v
 	}
 
-	for k, v := range m2 {
+	for _, k := range keys(m2, o.deterministicOrder) {
+		v := m2[k]
 		switch mergeType {
 		case ReplaceByIndexPreferRightRec, ReplaceByIndexPreferLeftRec, ReplaceByIndexPreferMaxRec:
 			if reflect.TypeOf(v).Kind() == reflect.Slice && reflect.TypeOf(result[k]).Kind() == reflect.Slice {
 				result[k] = mergeSlice(result[k], v, mergeType)
 			} else if reflect.TypeOf(v).Kind() == reflect.Map && reflect.TypeOf(result[k]).Kind() == reflect.Map {
-				result[k] = MergeMapStructure(result[k].(map[string]interface{}), v.(map[string]interface{}), mergeType)
+				result[k] = MergeMapStructure(result[k].(map[string]interface{}), v.(map[string]interface{}), mergeType, opts...)
 			} else {
 				result[k] = v
 			}
@@ -39,7 +60,7 @@ func MergeMapStructure(m1, m2 map[string]interface{}, mergeType string) map[stri
 				if reflect.TypeOf(result[k]).Kind() != reflect.Map {
 					result[k] = make(map[string]interface{})
 				}
-				result[k] = MergeMapStructure(result[k].(map[string]interface{}), v.(map[string]interface{}), mergeType)
+				result[k] = MergeMapStructure(result[k].(map[string]interface{}), v.(map[string]interface{}), mergeType, opts...)
 			case reflect.Slice:
 				if reflect.TypeOf(result[k]).Kind() != reflect.Slice {
 					// 创建一个类型为v的slice
@@ -56,6 +77,18 @@ func MergeMapStructure(m1, m2 map[string]interface{}, mergeType string) map[stri
 	return result
 }
 
+// keys 返回m的所有键，sorted为true时按升序排列
+func keys(m map[string]interface{}, sorted bool) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	if sorted {
+		sort.Strings(result)
+	}
+	return result
+}
+
 // removeDuplicates 去重
 func removeDuplicates(s1, s2 interface{}) []interface{} {
 	// 将s1和s2转换为reflect.Value类型
@@ -0,0 +1,291 @@
+// Command merge merges JSON and YAML files and writes the result to stdout.
+//
+//	merge [flags] base.yaml overlay1.json overlay2.yaml > out.yaml
+//
+// Every file is merged over the result of the previous ones, with the semantics of
+// merge.Merge: values already set are kept unless -overwrite is given, lists are
+// replaced, appended with -append or merged item by item with -merge-key. With
+// -patch the overlays are JSON Merge Patches (RFC 7386) instead. YAML output keeps
+// the key order and comments of the base file.
+//
+// The exit status is 1 on errors, 2 on bad usage and 3 when -strict finds conflicts,
+// values set by an overlay that an earlier file set differently; nothing is written
+// to stdout in that case.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cloudlibraries/merge"
+	"github.com/cloudlibraries/merge/loader"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	exitError    = 1
+	exitUsage    = 2
+	exitConflict = 3
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+type config struct {
+	overwrite bool
+	append    bool
+	mergeKeys string
+	patch     bool
+	output    string
+	report    bool
+	strict    bool
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	var cfg config
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.BoolVar(&cfg.overwrite, "overwrite", false, "replace values already set by earlier files")
+	fs.BoolVar(&cfg.append, "append", false, "append lists instead of replacing them")
+	fs.StringVar(&cfg.mergeKeys, "merge-key", "", "comma separated `keys` matching list items to merge, e.g. name")
+	fs.BoolVar(&cfg.patch, "patch", false, "apply overlays as JSON Merge Patches (RFC 7386)")
+	fs.StringVar(&cfg.output, "o", "", "output `format`, json or yaml (default: the format of the base file)")
+	fs.BoolVar(&cfg.report, "report", false, "print the changes made to the base file to stderr")
+	fs.BoolVar(&cfg.strict, "strict", false, "fail when an overlay sets a value an earlier file set differently")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: merge [flags] base overlay...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if cfg.output == "" {
+		cfg.output = "yaml"
+		if loader.FormatOf(fs.Arg(0)) == loader.FormatJSON {
+			cfg.output = "json"
+		}
+	}
+	if cfg.output != "json" && cfg.output != "yaml" {
+		fmt.Fprintf(stderr, "merge: unknown output format %q\n", cfg.output)
+		return exitUsage
+	}
+
+	result, err := mergeFiles(fs.Args(), cfg)
+	var conflicts *conflictError
+	switch {
+	case errors.As(err, &conflicts):
+		for _, c := range conflicts.conflicts {
+			fmt.Fprintf(stderr, "merge: conflict: %s\n", c)
+		}
+		return exitConflict
+	case err != nil:
+		fmt.Fprintf(stderr, "merge: %v\n", err)
+		return exitError
+	}
+
+	if cfg.report {
+		for _, change := range merge.Diff(result.before, result.after) {
+			fmt.Fprintln(stderr, change)
+		}
+	}
+	if err = write(stdout, result, cfg.output); err != nil {
+		fmt.Fprintf(stderr, "merge: %v\n", err)
+		return exitError
+	}
+	return 0
+}
+
+type result struct {
+	before, after map[string]interface{}
+	// node is the merged document when it was merged as YAML nodes.
+	node *yaml.Node
+}
+
+type conflictError struct {
+	conflicts []string
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("%d conflicts", len(e.conflicts))
+}
+
+func mergeFiles(paths []string, cfg config) (*result, error) {
+	var opts []merge.Option
+	if cfg.overwrite {
+		opts = append(opts, merge.WithOverwrite())
+	}
+	if cfg.append {
+		opts = append(opts, merge.WithAppendSlice())
+	}
+	if cfg.mergeKeys != "" {
+		opts = append(opts, merge.WithMergeKeys(strings.Split(cfg.mergeKeys, ",")...))
+	}
+
+	base, baseNode, err := readFile(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	res := &result{before: base}
+	var (
+		tree      interface{} = copyTree(base)
+		conflicts []string
+	)
+	if !cfg.patch {
+		res.node = baseNode
+	}
+	for _, path := range paths[1:] {
+		overlay, node, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.strict {
+			listsMerged := !cfg.patch && (cfg.append || cfg.mergeKeys != "")
+			conflicts = findConflicts(tree, overlay, nil, path, listsMerged, conflicts)
+		}
+		if cfg.patch {
+			tree = merge.MergePatch(tree, overlay)
+			continue
+		}
+		if err = merge.MergeYAMLNodes(res.node, node, opts...); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if tree, err = decodeNode(res.node); err != nil {
+			return nil, err
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, &conflictError{conflicts}
+	}
+	res.after, _ = tree.(map[string]interface{})
+	return res, nil
+}
+
+// readFile reads a JSON or YAML file as a tree and as a YAML node.
+func readFile(path string) (map[string]interface{}, *yaml.Node, error) {
+	tree, node, err := loader.ReadFile(loader.File{Path: path})
+	if err != nil {
+		return nil, nil, err
+	}
+	if node == nil || node.Kind == 0 {
+		// Empty files, and JSON that yaml.v3 cannot read, are rebuilt from the tree.
+		node = &yaml.Node{}
+		if err = node.Encode(tree); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if loader.FormatOf(path) == loader.FormatJSON {
+		// JSON is flow style YAML: drop the styles to write it as block YAML.
+		clearStyle(node)
+	}
+	return tree, node, nil
+}
+
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+func decodeNode(node *yaml.Node) (interface{}, error) {
+	var v interface{}
+	if err := node.Decode(&v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return map[string]interface{}{}, nil
+	}
+	return loader.Normalize(v), nil
+}
+
+// copyTree deep copies the maps and slices of a decoded tree.
+func copyTree(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			ret[key] = copyTree(value)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, value := range v {
+			ret[i] = copyTree(value)
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
+// findConflicts appends the values of overlay that tree already has, set differently.
+// Lists are only compared when they replace each other.
+func findConflicts(tree, overlay interface{}, path []string, file string, listsMerged bool, conflicts []string) []string {
+	if fields, ok := overlay.(map[string]interface{}); ok {
+		if current, ok := tree.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if value, ok := current[key]; ok {
+					conflicts = findConflicts(value, fields[key], append(path[:len(path):len(path)], key), file, listsMerged, conflicts)
+				}
+			}
+			return conflicts
+		}
+	}
+	if isEmpty(tree) || overlay == nil || reflect.DeepEqual(tree, overlay) {
+		return conflicts
+	}
+	if _, ok := overlay.([]interface{}); ok && listsMerged {
+		if _, ok := tree.([]interface{}); ok {
+			return conflicts
+		}
+	}
+	return append(conflicts, fmt.Sprintf("%s: %s: %v, already set to %v", file, strings.Join(path, "."), overlay, tree))
+}
+
+// isEmpty reports whether v is unset, the way merge sees empty values.
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice:
+		return rv.Len() == 0
+	default:
+		return rv.IsZero()
+	}
+}
+
+func write(w io.Writer, res *result, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res.after)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if res.node != nil {
+		if err := enc.Encode(res.node); err != nil {
+			return err
+		}
+	} else if err := enc.Encode(res.after); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.yaml":    "# base\nname: app # the name\nimage:\n  tag: \"\"\nenv:\n  - name: MODE\n    value: dev\n",
+		"overlay.json": `{"image":{"tag":"1.25"},"env":[{"name":"MODE","value":"prod"},{"name":"DEBUG","value":"1"}]}`,
+	})
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-overwrite", "-merge-key", "name", "-report",
+		filepath.Join(dir, "base.yaml"), filepath.Join(dir, "overlay.json")}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr.String())
+	}
+	expected := "# base\nname: app # the name\nimage:\n  tag: \"1.25\"\nenv:\n  - name: MODE\n    value: prod\n  - name: DEBUG\n    value: \"1\"\n"
+	if got := stdout.String(); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+	report := "replace env: [map[name:MODE value:dev]] -> [map[name:MODE value:prod] map[name:DEBUG value:1]]\nreplace image.tag:  -> 1.25\n"
+	if got := stderr.String(); got != report {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, report)
+	}
+}
+
+func TestRunPatch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.json":  `{"name":"app","debug":true,"db":{"host":"localhost"}}`,
+		"patch.json": `{"debug":null,"db":{"host":"db.internal"}}`,
+	})
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-patch", filepath.Join(dir, "base.json"), filepath.Join(dir, "patch.json")}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr.String())
+	}
+	expected := "{\n  \"db\": {\n    \"host\": \"db.internal\"\n  },\n  \"name\": \"app\"\n}\n"
+	if got := stdout.String(); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestRunStrict(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.yaml":    "name: app\nhost: \"\"\n",
+		"overlay.yaml": "name: svc\nhost: localhost\n",
+	})
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-strict", filepath.Join(dir, "base.yaml"), filepath.Join(dir, "overlay.yaml")}, &stdout, &stderr)
+	if code != exitConflict {
+		t.Fatalf("exit status %d, expected %d", code, exitConflict)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("nothing should be written on conflicts, got %q", stdout.String())
+	}
+	expected := "merge: conflict: " + filepath.Join(dir, "overlay.yaml") + ": name: svc, already set to app\n"
+	if got := stderr.String(); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run(nil, &stdout, &stderr); code != exitUsage {
+		t.Errorf("exit status %d, expected %d", code, exitUsage)
+	}
+}
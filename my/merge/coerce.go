@@ -0,0 +1,264 @@
+package merge
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCannotCoerce is returned when a value cannot be converted to the type of its destination.
+var ErrCannotCoerce = errors.New("cannot coerce value")
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// coerceValue converts v to typ. These are the rules Map follows, given WithCoercion,
+// when a src value does not have the type of the field it lands in:
+//   - assignable values are kept as they are, integers are converted to other integer and
+//     float types when they fit, floats are never truncated into integers;
+//   - strings are parsed into booleans, numbers, time.Duration ("30s"), time.Time (RFC 3339),
+//     encoding.TextUnmarshaler implementations, slices ("[a,b]" or "a,b") and maps ("k=v,k2=v2");
+//   - slices and maps are converted element by element, pointers are allocated on demand
+//     and map[string]interface{} values are mapped onto structs, ignoring the case of
+//     their keys if lenient, as WithLenientKeys does.
+func coerceValue(v reflect.Value, typ reflect.Type, lenient bool) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return reflect.Zero(typ), nil
+	}
+	if v.Type().AssignableTo(typ) {
+		return v, nil
+	}
+	if v.Kind() == reflect.String && typ.Kind() != reflect.Ptr {
+		return coerceString(v.String(), typ)
+	}
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if isIntegerKind(v.Kind()) {
+			return convertInteger(v, typ)
+		}
+	case reflect.Float32, reflect.Float64:
+		if isIntegerKind(v.Kind()) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			return v.Convert(typ), nil
+		}
+	case reflect.Ptr:
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Zero(typ), nil
+			}
+			v = v.Elem()
+		}
+		elem, err := coerceValue(v, typ.Elem(), lenient)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(typ.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			break
+		}
+		n := v.Len()
+		ret := reflect.New(typ).Elem()
+		if typ.Kind() == reflect.Slice {
+			ret = reflect.MakeSlice(typ, n, n)
+		} else if n > typ.Len() {
+			return reflect.Value{}, fmt.Errorf("%w: %d elements into %s", ErrCannotCoerce, n, typ)
+		}
+		for i := 0; i < n; i++ {
+			elem, err := coerceValue(v.Index(i), typ.Elem(), lenient)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("index %d: %w", i, err)
+			}
+			ret.Index(i).Set(elem)
+		}
+		return ret, nil
+	case reflect.Map:
+		if v.Kind() != reflect.Map {
+			break
+		}
+		ret := reflect.MakeMapWithSize(typ, v.Len())
+		for _, key := range v.MapKeys() {
+			k, err := coerceValue(key, typ.Key(), lenient)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %w", key.Interface(), err)
+			}
+			elem, err := coerceValue(v.MapIndex(key), typ.Elem(), lenient)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %w", key.Interface(), err)
+			}
+			ret.SetMapIndex(k, elem)
+		}
+		return ret, nil
+	case reflect.Struct:
+		if v.Kind() != reflect.Map {
+			break
+		}
+		if _, ok := v.Interface().(map[string]interface{}); !ok {
+			break
+		}
+		ret := reflect.New(typ).Elem()
+		if err := deepMap(ret, v, make(map[uintptr]*visit), 0, nil, &Options{coerce: true, lenientKeys: lenient}); err != nil {
+			return reflect.Value{}, err
+		}
+		return ret, nil
+	}
+	if v.Type().ConvertibleTo(typ) && v.Kind() == typ.Kind() {
+		return v.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("%w: %s into %s", ErrCannotCoerce, v.Type(), typ)
+}
+
+// coerceString parses s into a value of type typ.
+func coerceString(s string, typ reflect.Type) (reflect.Value, error) {
+	ret := reflect.New(typ).Elem()
+
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		if err := ret.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return reflect.Value{}, fmt.Errorf("%w: %q into %s: %v", ErrCannotCoerce, s, typ, err)
+		}
+		return ret, nil
+	}
+
+	var err error
+	switch typ.Kind() {
+	case reflect.String:
+		ret.SetString(s)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			ret.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if typ == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(s)
+			i = int64(d)
+		} else {
+			i, err = strconv.ParseInt(s, 0, typ.Bits())
+		}
+		if err == nil {
+			ret.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 0, typ.Bits()); err == nil {
+			ret.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(s, typ.Bits()); err == nil {
+			ret.SetFloat(f)
+		}
+	case reflect.Ptr:
+		var elem reflect.Value
+		if elem, err = coerceString(s, typ.Elem()); err == nil {
+			ret = reflect.New(typ.Elem())
+			ret.Elem().Set(elem)
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			ret.SetBytes([]byte(s))
+			break
+		}
+		items := splitList(s)
+		ret = reflect.MakeSlice(typ, len(items), len(items))
+		for i, item := range items {
+			var elem reflect.Value
+			if elem, err = coerceString(item, typ.Elem()); err != nil {
+				break
+			}
+			ret.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		ret = reflect.MakeMap(typ)
+		for _, item := range splitList(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "{"), "}")) {
+			k, v, ok := strings.Cut(item, "=")
+			if !ok {
+				k, v, ok = strings.Cut(item, ":")
+			}
+			if !ok {
+				err = fmt.Errorf("missing value for key %q", item)
+				break
+			}
+			var key, elem reflect.Value
+			if key, err = coerceString(strings.TrimSpace(k), typ.Key()); err != nil {
+				break
+			}
+			if elem, err = coerceString(strings.TrimSpace(v), typ.Elem()); err != nil {
+				break
+			}
+			ret.SetMapIndex(key, elem)
+		}
+	case reflect.Interface:
+		if typ.NumMethod() == 0 {
+			ret.Set(reflect.ValueOf(s))
+			break
+		}
+		err = errors.New("unsupported interface")
+	default:
+		if typ == timeType {
+			var t time.Time
+			if t, err = time.Parse(time.RFC3339, s); err == nil {
+				ret.Set(reflect.ValueOf(t))
+			}
+			break
+		}
+		err = errors.New("unsupported type")
+	}
+	if err != nil {
+		if errors.Is(err, ErrCannotCoerce) {
+			return reflect.Value{}, err
+		}
+		return reflect.Value{}, fmt.Errorf("%w: %q into %s: %v", ErrCannotCoerce, s, typ, err)
+	}
+	return ret, nil
+}
+
+// splitList splits "[a, b]" or "a,b" into its trimmed items.
+func splitList(s string) []string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	items := strings.Split(s, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	return reflect.Int <= k && k <= reflect.Uintptr
+}
+
+// convertInteger converts between integer types, refusing values that do not fit.
+func convertInteger(v reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	ret := v.Convert(typ)
+	fits := ret.Convert(v.Type()).Interface() == v.Interface()
+	if v.Kind() >= reflect.Uint && typ.Kind() < reflect.Uint {
+		fits = fits && ret.Int() >= 0
+	} else if v.Kind() < reflect.Uint && typ.Kind() >= reflect.Uint {
+		fits = fits && v.Int() >= 0
+	}
+	if !fits {
+		return reflect.Value{}, fmt.Errorf("%w: %v overflows %s", ErrCannotCoerce, v.Interface(), typ)
+	}
+	return ret, nil
+}
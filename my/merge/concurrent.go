@@ -0,0 +1,140 @@
+package merge
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// parallelThreshold is the number of map entries or slice elements from which
+// WithConcurrency merges them concurrently.
+const parallelThreshold = 1024
+
+// parallelMapKeys returns, sorted, the keys of src whose entries can be merged
+// concurrently into dst: both are non-nil pointers, distinct from every other pointer
+// of dst, so merging them writes through the pointers and never to the map itself.
+// It returns nil when the map is too small or its entries are not pointers.
+func (config *Options) parallelMapKeys(dst, src reflect.Value) []reflect.Value {
+	if config.concurrency < 2 || src.Len() < parallelThreshold || dst.Type().Elem().Kind() != reflect.Ptr {
+		return nil
+	}
+	keys := make([]reflect.Value, 0, src.Len())
+	targets := make(map[uintptr]bool, src.Len())
+	for _, key := range sortedMapKeys(src) {
+		dstElement, srcElement := dst.MapIndex(key), src.MapIndex(key)
+		if !dstElement.IsValid() || dstElement.IsNil() || srcElement.IsNil() {
+			continue
+		}
+		if targets[dstElement.Pointer()] {
+			// Shared pointers would be merged twice at the same time.
+			return nil
+		}
+		targets[dstElement.Pointer()] = true
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if targets[src.MapIndex(key).Pointer()] {
+			return nil
+		}
+	}
+	if len(keys) < parallelThreshold {
+		return nil
+	}
+	return keys
+}
+
+// mergeMapParallel merges the entries at keys, see parallelMapKeys.
+func (config *Options) mergeMapParallel(dst, src reflect.Value, keys []reflect.Value, depth int, path fieldPath) error {
+	// Hook replacements are written to the map once the entries are merged.
+	replaced := make([]error, len(keys))
+	err := config.parallel(len(keys), func(i int, visited map[uintptr]*visit, inner *Options) error {
+		keyPath := config.keyPath(path, keys[i])
+		dstElement, srcElement := dst.MapIndex(keys[i]), src.MapIndex(keys[i])
+		if err := inner.checkImmutable(dstElement, srcElement, keyPath, nil); err != nil {
+			return err
+		}
+		err := deepMerge(dstElement, srcElement, visited, depth+1, keyPath, inner)
+		if _, ok := err.(*replacedError); ok {
+			replaced[i] = err
+			return nil
+		}
+		return err
+	})
+	for i := 0; err == nil && i < len(keys); i++ {
+		if replaced[i] != nil {
+			err = config.replaceMapElement(dst, keys[i], replaced[i])
+		}
+	}
+	return err
+}
+
+// parallelElements reports whether the first n elements of dst can be merged concurrently:
+// they are structs or distinct non-nil pointers.
+func (config *Options) parallelElements(dst reflect.Value, n int) bool {
+	if config.concurrency < 2 || n < parallelThreshold {
+		return false
+	}
+	switch dst.Type().Elem().Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Ptr:
+		targets := make(map[uintptr]bool, n)
+		for i := 0; i < n; i++ {
+			elem := dst.Index(i)
+			if elem.IsNil() || targets[elem.Pointer()] {
+				return false
+			}
+			targets[elem.Pointer()] = true
+		}
+		return true
+	}
+	return false
+}
+
+// parallel calls merge for 0 <= i < n on config.concurrency goroutines, each with its
+// own visited map and its own copy of config, merging sequentially below. Every index is
+// processed until one fails; the error of the lowest failing index is returned, which
+// makes errors independent from scheduling.
+func (config *Options) parallel(n int, merge func(i int, visited map[uintptr]*visit, inner *Options) error) error {
+	workers := config.concurrency
+	if workers > n {
+		workers = n
+	}
+	var (
+		wg     sync.WaitGroup
+		next   int64 = -1
+		failed int64 = int64(n)
+		errs         = make([]error, n)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			visited := make(map[uintptr]*visit)
+			inner := *config
+			inner.concurrency = 0
+			for {
+				i := atomic.AddInt64(&next, 1)
+				if i >= int64(n) || i > atomic.LoadInt64(&failed) {
+					return
+				}
+				if errs[i] = merge(int(i), visited, &inner); errs[i] != nil {
+					for {
+						lowest := atomic.LoadInt64(&failed)
+						if i >= lowest || atomic.CompareAndSwapInt64(&failed, lowest, i) {
+							break
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package merge
+
+import "context"
+
+// contextCheckInterval is the number of values merged between two checks of the context.
+const contextCheckInterval = 64
+
+// MergeContext is Merge, stopping when ctx is done. The error is then a *PathError
+// wrapping ctx.Err() with the path reached. dst is left partially merged: the values
+// walked before that path are merged and the others are not. To keep dst untouched,
+// merge into a copy of it and use the copy once MergeContext succeeds.
+func MergeContext(ctx context.Context, dst, src interface{}, opts ...Option) error {
+	return merge(dst, src, append(opts[:len(opts):len(opts)], withContext(ctx))...)
+}
+
+// MapContext is Map, stopping when ctx is done, see MergeContext.
+func MapContext(ctx context.Context, dst, src interface{}, opts ...Option) error {
+	return _map(dst, src, append(opts[:len(opts):len(opts)], withContext(ctx))...)
+}
+
+func withContext(ctx context.Context) Option {
+	return func(config *Options) {
+		config.ctx = ctx
+	}
+}
+
+// checkContext returns the error of the context, every contextCheckInterval values.
+func (config *Options) checkContext(path fieldPath) error {
+	if config.ctx == nil {
+		return nil
+	}
+	config.steps++
+	if config.steps%contextCheckInterval != 1 {
+		return nil
+	}
+	if err := config.ctx.Err(); err != nil {
+		return &PathError{path.String(), err}
+	}
+	return nil
+}
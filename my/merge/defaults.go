@@ -0,0 +1,113 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// defaultTagName is the struct tag holding the default value of a field,
+// e.g. `default:"8080"`, `default:"30s"` or `default:"[a,b]"`.
+const defaultTagName = "default"
+
+// ApplyDefaults fills every empty field of the struct dst points to with the value of its
+// `default:"..."` tag. Tag values are parsed with the same coercion rules Map uses for
+// string values. Nested structs are visited recursively and nil pointers to structs are
+// allocated when something below them has a default, unless their type is already being
+// filled further up: a recursive type such as a linked list node is not grown forever.
+func ApplyDefaults(dst interface{}) error {
+	if dst == nil {
+		return ErrNilArguments
+	}
+	vDst := reflect.ValueOf(dst)
+	if vDst.Kind() != reflect.Ptr {
+		return ErrNonPointerAgument
+	}
+	if vDst.Elem().Kind() != reflect.Struct {
+		return ErrExpectedStructAsDestination
+	}
+	return applyDefaults(vDst.Elem(), nil, newDefaultsVisit())
+}
+
+// defaultsVisit tracks the struct types being filled and the pointers already followed.
+type defaultsVisit struct {
+	types    map[reflect.Type]int
+	pointers map[uintptr]bool
+}
+
+func newDefaultsVisit() *defaultsVisit {
+	return &defaultsVisit{types: make(map[reflect.Type]int), pointers: make(map[uintptr]bool)}
+}
+
+func applyDefaults(dst reflect.Value, path fieldPath, visit *defaultsVisit) error {
+	visit.types[dst.Type()]++
+	defer func() { visit.types[dst.Type()]-- }()
+
+	plan := planOf(dst.Type())
+	for i := range plan.fields {
+		field := &plan.fields[i]
+		if !field.exported {
+			continue
+		}
+		value := dst.Field(i)
+		if !value.CanSet() {
+			continue
+		}
+		fieldPath := field.path(path)
+
+		if tag, ok := field.Tag.Lookup(defaultTagName); ok && isEmptyValue(value) {
+			v, err := coerceString(tag, field.Type)
+			if err != nil {
+				return &PathError{fieldPath.String(), fmt.Errorf("invalid default: %w", err)}
+			}
+			value.Set(v)
+			continue
+		}
+
+		switch {
+		case value.Kind() == reflect.Struct:
+			if err := applyDefaults(value, fieldPath, visit); err != nil {
+				return err
+			}
+		case value.Kind() == reflect.Ptr && value.Type().Elem().Kind() == reflect.Struct:
+			if value.IsNil() {
+				elem := value.Type().Elem()
+				if visit.types[elem] > 0 || !hasDefaults(elem, map[reflect.Type]bool{}) {
+					continue
+				}
+				value.Set(reflect.New(elem))
+			} else if visit.pointers[value.Pointer()] {
+				continue
+			}
+			visit.pointers[value.Pointer()] = true
+			if err := applyDefaults(value.Elem(), fieldPath, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasDefaults reports whether typ, or a struct reachable from it, has a default tag.
+func hasDefaults(typ reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[typ] {
+		return false
+	}
+	seen[typ] = true
+	for i, n := 0, typ.NumField(); i < n; i++ {
+		field := typ.Field(i)
+		if !isExported(field) && !field.Anonymous {
+			continue
+		}
+		if _, ok := field.Tag.Lookup(defaultTagName); ok {
+			return true
+		}
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && hasDefaults(ft, seen) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package merge
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvOption customizes FromEnv.
+type EnvOption func(*envOptions)
+
+type envOptions struct {
+	environ   func() []string
+	separator string
+}
+
+// WithEnviron makes FromEnv read the variables returned by environ, in the "KEY=value"
+// form of os.Environ, instead of the process environment.
+func WithEnviron(environ func() []string) EnvOption {
+	return func(config *envOptions) {
+		config.environ = environ
+	}
+}
+
+// WithEnvSeparator changes the separator between nesting levels, "__" by default.
+func WithEnvSeparator(separator string) EnvOption {
+	return func(config *envOptions) {
+		config.separator = separator
+	}
+}
+
+var envIndexSuffix = regexp.MustCompile(`^(?:(.*)_)?([0-9]+)$`)
+
+// maxEnvIndex bounds the slice indexes of FromEnv, which allocates every element up to
+// the index: APP_HOSTS_999999999 must not build a slice of a billion elements.
+const maxEnvIndex = 1 << 16
+
+// FromEnv builds a source tree from the environment variables starting with prefix,
+// ready to be given to Map or Merge. The prefix and its trailing underscore are removed,
+// "__" separates nesting levels and names are lower cased, so APP_DB__POOL_SIZE=10
+// becomes {"db": {"pool_size": "10"}}.
+// A trailing number is a slice index, as in APP_HOSTS_0=a or APP_SERVERS_1__PORT=80;
+// comma-separated values such as APP_HOSTS=a,b are split when they land in a slice
+// and APP_LABELS=team=core,tier=gold fills a map. Variables with an index above 65536
+// are ignored.
+//
+// Mapping the tree onto a struct takes both WithLenientKeys and WithCoercion:
+//
+//	err := Map(&cfg, FromEnv("APP"), WithOverwrite(), WithLenientKeys(), WithCoercion())
+//
+// Without WithLenientKeys, pool_size is no key of DB.PoolSize and is ignored. Values
+// are left as strings: without WithCoercion, Map fails on a field that is no string.
+func FromEnv(prefix string, opts ...EnvOption) map[string]interface{} {
+	config := &envOptions{
+		environ:   os.Environ,
+		separator: "__",
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	environ := config.environ()
+	sort.Strings(environ)
+
+	tree := make(map[string]interface{})
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, prefix) || len(key) == len(prefix) {
+			continue
+		}
+		path, ok := envPath(key[len(prefix):], config.separator)
+		if !ok {
+			continue
+		}
+		tree = setTreeValue(tree, path, value).(map[string]interface{})
+	}
+	return tree
+}
+
+// envPath splits the name of a variable, without its prefix, into the path of its value.
+// ok is false when the path starts with an index or holds an index above maxEnvIndex.
+func envPath(name, separator string) (path []interface{}, ok bool) {
+	for _, segment := range strings.Split(name, separator) {
+		segment = strings.ToLower(segment)
+		if m := envIndexSuffix.FindStringSubmatch(segment); m != nil {
+			index, err := strconv.Atoi(m[2])
+			if err != nil || index > maxEnvIndex {
+				return nil, false
+			}
+			if m[1] != "" {
+				path = append(path, m[1])
+			}
+			path = append(path, index)
+			continue
+		}
+		path = append(path, segment)
+	}
+	_, ok = path[0].(string)
+	return path, ok
+}
+
+// setTreeValue stores value at path below node, creating maps for names and slices
+// for indexes as needed, and returns the updated node.
+func setTreeValue(node interface{}, path []interface{}, value string) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	switch segment := path[0].(type) {
+	case int:
+		list, _ := node.([]interface{})
+		for len(list) <= segment {
+			list = append(list, nil)
+		}
+		list[segment] = setTreeValue(list[segment], path[1:], value)
+		return list
+	default:
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			m = make(map[string]interface{})
+		}
+		name := segment.(string)
+		m[name] = setTreeValue(m[name], path[1:], value)
+		return m
+	}
+}
@@ -0,0 +1,113 @@
+package merge
+
+import (
+	"path"
+	"strings"
+)
+
+// pathPattern is a dotted glob over value paths. A "*" segment matches exactly
+// one path segment, a "**" segment matches any number of them and any other
+// segment is matched with path.Match, e.g. "spec.containers.*.image" or
+// "metadata.labels.**".
+type pathPattern []string
+
+func compilePatterns(patterns []string) []pathPattern {
+	compiled := make([]pathPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled = append(compiled, strings.Split(pattern, "."))
+	}
+	return compiled
+}
+
+// match reports whether p is matched by the pattern.
+func (pt pathPattern) match(p fieldPath) bool {
+	return matchSegments(pt, p, false)
+}
+
+// matchDescendant reports whether some path below p could be matched by the pattern.
+func (pt pathPattern) matchDescendant(p fieldPath) bool {
+	return matchSegments(pt, p, true)
+}
+
+func matchSegments(pattern []string, segments []string, descendant bool) bool {
+	if len(segments) == 0 {
+		if descendant {
+			return len(pattern) > 0
+		}
+		for _, segment := range pattern {
+			if segment != "**" {
+				return false
+			}
+		}
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return matchSegments(pattern[1:], segments, descendant) || matchSegments(pattern, segments[1:], descendant)
+	}
+	if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:], descendant)
+}
+
+func matchAny(patterns []pathPattern, p fieldPath) bool {
+	for _, pattern := range patterns {
+		if pattern.match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyDescendant(patterns []pathPattern, p fieldPath) bool {
+	for _, pattern := range patterns {
+		if pattern.matchDescendant(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyAncestor reports whether p or one of its ancestors is matched.
+func matchAnyAncestor(patterns []pathPattern, p fieldPath) bool {
+	for i := len(p); i >= 0; i-- {
+		if matchAny(patterns, p[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterMode int
+
+const (
+	// filterAllow lets merge write the value and everything below it.
+	filterAllow filterMode = iota
+	// filterDescend lets merge walk into the value but not replace it as a whole.
+	filterDescend
+	// filterSkip leaves the value in dst exactly as it is.
+	filterSkip
+)
+
+// filter decides, from WithInclude and WithExclude, what merge may do at p.
+func (config *Options) filter(p fieldPath) filterMode {
+	if len(config.include) == 0 && len(config.exclude) == 0 {
+		return filterAllow
+	}
+	if matchAnyAncestor(config.exclude, p) {
+		return filterSkip
+	}
+	if len(config.include) > 0 && !matchAnyAncestor(config.include, p) {
+		if matchAnyDescendant(config.include, p) {
+			return filterDescend
+		}
+		return filterSkip
+	}
+	if matchAnyDescendant(config.exclude, p) {
+		return filterDescend
+	}
+	return filterAllow
+}
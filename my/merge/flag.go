@@ -0,0 +1,87 @@
+package merge
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+)
+
+// flagTagName is the struct tag naming the flag that sets a field, e.g. `flag:"db-pool-size"`.
+const flagTagName = "flag"
+
+// Source is implemented by sources whose tree depends on the value it is merged into.
+// Merge and Map call Tree with their dst and merge the returned tree instead of the source.
+type Source interface {
+	Tree(dst interface{}) map[string]interface{}
+}
+
+// FlagSource is a Source holding the flags of a flag.FlagSet that were explicitly set.
+type FlagSource struct {
+	flags []*flag.Flag
+}
+
+// FromFlagSet returns a Source made of the flags that were set on fs when it was parsed,
+// as reported by fs.Visit. Flags left to their default are not part of it, so they never
+// override the values merged from files or the environment with their zero value.
+func FromFlagSet(fs *flag.FlagSet) *FlagSource {
+	s := &FlagSource{}
+	fs.Visit(func(f *flag.Flag) {
+		s.flags = append(s.flags, f)
+	})
+	return s
+}
+
+// Tree returns the set flags as a source tree for dst. A flag named in the `flag:"..."` tag
+// of a field of dst, at any depth, lands on that field; any other flag name is split on
+// dots, so "db.pool-size" lands on DB.PoolSize given WithLenientKeys. Values are the flag values as strings,
+// Map coerces them to the type of their field when given WithCoercion.
+func (s *FlagSource) Tree(dst interface{}) map[string]interface{} {
+	paths := make(map[string][]interface{})
+	if t := reflect.TypeOf(dst); t != nil {
+		collectFlagPaths(t, nil, paths, make(map[reflect.Type]bool))
+	}
+
+	tree := make(map[string]interface{})
+	for _, f := range s.flags {
+		path, ok := paths[f.Name]
+		if !ok {
+			for _, segment := range strings.Split(f.Name, ".") {
+				path = append(path, segment)
+			}
+		}
+		tree = setTreeValue(tree, path, f.Value.String()).(map[string]interface{})
+	}
+	return tree
+}
+
+func collectFlagPaths(t reflect.Type, prefix []interface{}, paths map[string][]interface{}, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for i, n := 0, t.NumField(); i < n; i++ {
+		field := t.Field(i)
+		if !isExported(field) {
+			continue
+		}
+		path := append(prefix[:len(prefix):len(prefix)], field.Name)
+		if name, ok := field.Tag.Lookup(flagTagName); ok && name != "" && name != "-" {
+			paths[name] = path
+			continue
+		}
+		collectFlagPaths(field.Type, path, paths, seen)
+	}
+}
+
+// resolveSource replaces a Source by the tree it produces for dst.
+func resolveSource(dst, src interface{}) interface{} {
+	if s, ok := src.(Source); ok {
+		return s.Tree(dst)
+	}
+	return src
+}
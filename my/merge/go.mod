@@ -1,6 +1,6 @@
 module github.com/cloudlibraries/merge
 
-go 1.19
+go 1.21
 
 require gopkg.in/yaml.v3 v3.0.1
 
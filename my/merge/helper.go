@@ -14,13 +14,15 @@ var (
 	ErrExpectedMapAsDestination    = errors.New("dst was expected to be a map")
 	ErrExpectedStructAsDestination = errors.New("dst was expected to be a struct")
 	ErrNonPointerAgument           = errors.New("dst must be a pointer")
+	ErrImmutableField              = errors.New("immutable field")
+	ErrWriteOnceField              = errors.New("write-once field already set")
 )
 
-func hasMergeableFields(dst reflect.Value) (exported bool) {
-	for i, n := 0, dst.NumField(); i < n; i++ {
-		field := dst.Type().Field(i)
-		if field.Anonymous && dst.Field(i).Kind() == reflect.Struct {
-			exported = exported || hasMergeableFields(dst.Field(i))
+func hasMergeableFields(typ reflect.Type) (exported bool) {
+	for i, n := 0, typ.NumField(); i < n; i++ {
+		field := typ.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			exported = exported || hasMergeableFields(field.Type)
 		} else if isExportedComponent(&field) {
 			exported = exported || len(field.PkgPath) == 0
 		}
@@ -104,6 +106,8 @@ func isZeroValue(v reflect.Value) bool {
 
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
+	case reflect.Invalid:
+		return true
 	case reflect.Map, reflect.Slice:
 		return v.Len() == 0
 	default:
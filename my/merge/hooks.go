@@ -0,0 +1,220 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+// SkipNode can be returned by a hook before a value is merged or written to leave
+// dst as it is at that path. It is not returned by Merge.
+var SkipNode = errors.New("skip this node")
+
+// HookPhase tells whether a hook is called before or after the action of its event.
+type HookPhase int
+
+const (
+	HookBefore HookPhase = iota
+	HookAfter
+)
+
+func (p HookPhase) String() string {
+	if p == HookAfter {
+		return "after"
+	}
+	return "before"
+}
+
+// HookAction is what merge does at the path of a hook event.
+type HookAction int
+
+const (
+	// ActionMerge merges src into dst, walking into them or writing src as a whole as
+	// the options say. Its write, if any, has its own ActionSet or ActionAppend events.
+	// A keyed slice held in a map, see WithMergeKeys, has its ActionMerge events once
+	// its elements are merged, when it is stored back in the map.
+	ActionMerge HookAction = iota
+	// ActionSet writes src over dst.
+	ActionSet
+	// ActionAppend appends the src slice to the dst slice.
+	ActionAppend
+)
+
+func (a HookAction) String() string {
+	switch a {
+	case ActionSet:
+		return "set"
+	case ActionAppend:
+		return "append"
+	default:
+		return "merge"
+	}
+}
+
+// HookEvent is passed to the hooks of WithHook around every value Merge and Map merge
+// and every value they write. Dst is invalid when the value is written to a new map
+// key or slice element; after an action, Dst holds its result.
+type HookEvent struct {
+	Phase  HookPhase
+	Action HookAction
+	Path   string
+	// Kind is the kind of Src, the kind of its dynamic value for interfaces.
+	Kind     reflect.Kind
+	Dst, Src reflect.Value
+
+	replacement *replacement
+}
+
+type replacement struct {
+	v   reflect.Value
+	set bool
+}
+
+// Replace makes merge use v instead of Src for the action about to be taken: v is then
+// written as a whole, even for ActionMerge. It has no effect after the action.
+func (ev HookEvent) Replace(v interface{}) {
+	if ev.replacement != nil {
+		*ev.replacement = replacement{reflect.ValueOf(v), true}
+	}
+}
+
+// WithHook adds a hook called before and after each value is merged or written. Hooks
+// run in the order they were added and their first error stops the merge, except
+// SkipNode before an action, which cancels it. With WithConcurrency, hooks may be
+// called from several goroutines at once.
+func WithHook(hook func(ev HookEvent) error) Option {
+	return func(config *Options) {
+		config.hooks = append(config.hooks, hook)
+	}
+}
+
+// hookBefore runs the hooks before action. It returns the value to use instead of
+// src, skip when a hook returned SkipNode, or the error of a hook.
+func (config *Options) hookBefore(action HookAction, path fieldPath, dst, src reflect.Value) (v reflect.Value, replaced, skip bool, err error) {
+	v = src
+	for _, hook := range config.hooks {
+		var r replacement
+		if err = hook(HookEvent{HookBefore, action, path.String(), hookKind(v), dst, v, &r}); err == SkipNode {
+			return v, false, true, nil
+		} else if err != nil {
+			return v, false, false, err
+		}
+		if r.set {
+			if v, err = replaceWith(r.v, src.Type(), path); err != nil {
+				return v, false, false, err
+			}
+			replaced = true
+		}
+	}
+	return v, replaced, false, nil
+}
+
+// hookAfter runs the hooks after action, dst holding its result.
+func (config *Options) hookAfter(action HookAction, path fieldPath, dst, src reflect.Value) error {
+	for _, hook := range config.hooks {
+		if err := hook(HookEvent{HookAfter, action, path.String(), hookKind(src), dst, src, nil}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hookKind(v reflect.Value) reflect.Kind {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		return v.Elem().Kind()
+	}
+	return v.Kind()
+}
+
+// replaceWith returns v as a value of typ, the zero value when v is nil.
+func replaceWith(v reflect.Value, typ reflect.Type, path fieldPath) (reflect.Value, error) {
+	if !v.IsValid() {
+		return reflect.Zero(typ), nil
+	}
+	if !v.Type().AssignableTo(typ) {
+		return reflect.Value{}, &PathError{path.String(), fmt.Errorf("%w: hook replaced %s with %s", ErrDifferentArgumentsTypes, typ, v.Type())}
+	}
+	if v.Type() != typ {
+		converted := reflect.New(typ).Elem()
+		converted.Set(v)
+		v = converted
+	}
+	return v, nil
+}
+
+// write stores v in dst at path with store, which returns the value then at path,
+// once the limits and the hooks allow it. An ActionMerge write stores a value merged
+// below path by writes of its own, which checked the limits and recorded the stats
+// and the provenance of what they wrote, unless a hook replaces it.
+func (config *Options) write(path fieldPath, action HookAction, dst, v reflect.Value, store func(v reflect.Value) reflect.Value) error {
+	if config.skipPaths && config.stats == nil {
+		// Neither hooks, limits, tracing nor provenance: nothing to do but store.
+		store(v)
+		return nil
+	}
+	var err error
+	var replaced bool
+	if len(config.hooks) > 0 {
+		var skip bool
+		if v, replaced, skip, err = config.hookBefore(action, path, dst, v); skip || err != nil {
+			if skip {
+				config.trace(path, "skip", dst, v, slog.String("reason", "hook"))
+			}
+			return err
+		}
+	}
+	merged := action == ActionMerge && !replaced
+	switch {
+	case merged:
+	case action == ActionAppend:
+		err = config.checkAppend(path, dst.Len()+v.Len(), v)
+	default:
+		err = config.checkWrite(path, v)
+	}
+	if err != nil {
+		return err
+	}
+	config.trace(path, action.String(), dst, v)
+	if !merged {
+		config.stats.wrote(action, dst)
+	}
+	stored := store(v)
+	if !merged {
+		config.written(path)
+	}
+	if len(config.hooks) > 0 {
+		return config.hookAfter(action, path, stored, v)
+	}
+	return nil
+}
+
+// set writes v over dst at path.
+func (config *Options) set(path fieldPath, dst, v reflect.Value) error {
+	return config.write(path, ActionSet, dst, v, setter(dst))
+}
+
+// keySetter returns the store function of write for the key of the map dst. A key
+// left holding nil, e.g. overwritten with an empty value, is counted as deleted.
+func (config *Options) keySetter(dst, key reflect.Value) func(v reflect.Value) reflect.Value {
+	return func(v reflect.Value) reflect.Value {
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		if config.stats != nil && isReflectNil(v) {
+			if old := dst.MapIndex(key); old.IsValid() && !isReflectNil(old) {
+				config.stats.keyDeleted()
+			}
+		}
+		dst.SetMapIndex(key, v)
+		return v
+	}
+}
+
+// setter returns the store function of write for a settable dst.
+func setter(dst reflect.Value) func(v reflect.Value) reflect.Value {
+	return func(v reflect.Value) reflect.Value {
+		dst.Set(v)
+		return dst
+	}
+}
@@ -0,0 +1,126 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// checkImmutable returns a *PathError when merging src into dst at path would
+// change a value marked immutable or write-once, by struct tag or by option.
+// Both may be filled while they are empty; once set, nothing merge writes below
+// them may change, src may only repeat them. Nothing is reported when merge would
+// leave dst as it is, e.g. a non-empty value without WithOverwrite.
+func (config *Options) checkImmutable(dst, src reflect.Value, path fieldPath, tag tagOptions) error {
+	immutable := tag.has("immutable") || matchAny(config.immutable, path)
+	writeOnce := tag.has("writeonce") || matchAny(config.writeOnce, path)
+	if !immutable && !writeOnce {
+		return nil
+	}
+	if config.filter(path) == filterSkip {
+		return nil
+	}
+	if dst = unwrapInterface(dst); !dst.IsValid() || isEmptyValue(dst) {
+		return nil
+	}
+	changed, from, to, at := config.changes(dst, src, path, tag)
+	if !changed {
+		return nil
+	}
+	sentinel := ErrImmutableField
+	if !immutable {
+		sentinel = ErrWriteOnceField
+	}
+	return &PathError{at.String(), fmt.Errorf("%w: cannot change %v to %v", sentinel, from, to)}
+}
+
+// changes reports whether merging src would change the non-empty dst at path, along
+// with the first value it would write and where. It walks the structs, maps and
+// pointers merge walks into, and compares the leaves merge would write.
+func (config *Options) changes(dst, src reflect.Value, path fieldPath, tag tagOptions) (changed bool, from, to interface{}, at fieldPath) {
+	dst, src = unwrapInterface(dst), unwrapInterface(src)
+	if !src.IsValid() || !src.CanInterface() || (dst.IsValid() && !dst.CanInterface()) {
+		return false, nil, nil, nil
+	}
+	if !dst.IsValid() || isEmptyValue(dst) {
+		if isEmptyValue(src) {
+			return false, nil, nil, nil
+		}
+		var old interface{}
+		if dst.IsValid() {
+			old = dst.Interface()
+		}
+		return true, old, src.Interface(), path
+	}
+	if dst.Type() == src.Type() && !isEmptyValue(src) && !config.combinesAt(dst, path, tag) {
+		switch dst.Kind() {
+		case reflect.Ptr:
+			return config.changes(dst.Elem(), src.Elem(), path, nil)
+		case reflect.Struct:
+			if !hasMergeableFields(dst.Type()) {
+				break
+			}
+			plan := planOf(dst.Type())
+			for i := range plan.fields {
+				field := &plan.fields[i]
+				if !field.exported {
+					continue
+				}
+				fieldPath := field.path(path)
+				if config.filter(fieldPath) == filterSkip {
+					continue
+				}
+				if changed, from, to, at = config.changes(dst.Field(i), src.Field(i), fieldPath, field.tag); changed {
+					return
+				}
+			}
+			return false, nil, nil, nil
+		case reflect.Map:
+			for _, key := range config.mapKeys(src) {
+				keyPath := path.key(key)
+				if config.filter(keyPath) == filterSkip {
+					continue
+				}
+				if changed, from, to, at = config.changes(dst.MapIndex(key), src.MapIndex(key), keyPath, nil); changed {
+					return
+				}
+			}
+			return false, nil, nil, nil
+		}
+	}
+	if !config.overwrites(dst, src, path, tag) || reflect.DeepEqual(dst.Interface(), src.Interface()) {
+		return false, nil, nil, nil
+	}
+	return true, dst.Interface(), src.Interface(), path
+}
+
+// combinesAt reports whether a set operation or a leaf strategy merges dst at path.
+func (config *Options) combinesAt(dst reflect.Value, path fieldPath, tag tagOptions) bool {
+	if tag == nil && !config.combines() {
+		return false
+	}
+	if _, ok, _ := config.setOp(path, tag); ok {
+		return true
+	}
+	_, ok, _ := config.leafStrategy(path, tag, dst.Type())
+	return ok
+}
+
+// overwrites reports whether merging src would write over the non-empty dst at path.
+// Without WithOverwrite merge only fills empty values, unless slices are appended to
+// or a set operation or a leaf strategy combines both sides.
+func (config *Options) overwrites(dst, src reflect.Value, path fieldPath, tag tagOptions) bool {
+	if isEmptyValue(src) {
+		return config.overwriteWithEmptyValue || (config.overwriteSliceWithEmptyValue && dst.Kind() == reflect.Slice)
+	}
+	if config.overwrite || (config.appendSlice && dst.Kind() == reflect.Slice) {
+		return true
+	}
+	return config.combinesAt(dst, path, tag)
+}
+
+func unwrapInterface(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		return v.Elem()
+	}
+	return v
+}
@@ -0,0 +1,265 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+var (
+	// ErrMissingReference is returned when a ${...} reference names no value.
+	ErrMissingReference = errors.New("missing reference")
+	// ErrReferenceCycle is returned when values reference each other in a loop.
+	ErrReferenceCycle = errors.New("reference cycle")
+	// ErrInvalidReference is returned for unterminated, empty or non-scalar references.
+	ErrInvalidReference = errors.New("invalid reference")
+)
+
+// InterpolateOption customizes Interpolate and WithInterpolation.
+type InterpolateOption func(*interpolation)
+
+type interpolation struct {
+	lookupEnv func(name string) (string, bool)
+}
+
+// WithLookupEnv makes ${env:NAME} references read their value from lookup, which
+// has the signature of os.LookupEnv, instead of the process environment.
+func WithLookupEnv(lookup func(name string) (string, bool)) InterpolateOption {
+	return func(config *interpolation) {
+		config.lookupEnv = lookup
+	}
+}
+
+// WithInterpolation will make merge, once done, resolve the references held by the
+// string values of dst as Interpolate does.
+// Only give it to the last of a series of merges, the references are gone once resolved.
+func WithInterpolation(opts ...InterpolateOption) Option {
+	return func(config *Options) {
+		config.interpolation = newInterpolation(opts)
+	}
+}
+
+// Interpolate replaces, in every string value of v, ${path} with the value found at
+// path in v and ${env:NAME} with the environment variable NAME. Paths are the dotted
+// paths merge reports, so "http://${server.host}:${server.port}" reads the Host and
+// Port fields of the Server field of a struct, or the "host" and "port" keys of the
+// "server" map of a map[string]interface{}. Referenced strings are resolved first,
+// numbers, booleans and fmt.Stringer values are formatted with fmt.Sprint.
+// $${ is written as a literal ${ and is not resolved.
+// v must be a pointer or a map. Missing references, malformed references and values
+// referencing each other in a loop are reported in a *PathError.
+func Interpolate(v interface{}, opts ...InterpolateOption) error {
+	return newInterpolation(opts).run(reflect.ValueOf(v))
+}
+
+func newInterpolation(opts []InterpolateOption) *interpolation {
+	config := &interpolation{lookupEnv: os.LookupEnv}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// interpolatedString is a string value holding at least one reference.
+type interpolatedString struct {
+	path  string
+	typ   reflect.Type
+	store func(reflect.Value)
+}
+
+type interpolationTree struct {
+	*interpolation
+	values   map[string]reflect.Value
+	raw      map[string]string
+	resolved map[string]string
+	stack    []string
+}
+
+func (config *interpolation) run(v reflect.Value) error {
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Map && !v.CanAddr() {
+		return ErrNonPointerAgument
+	}
+	t := &interpolationTree{
+		interpolation: config,
+		values:        make(map[string]reflect.Value),
+		raw:           make(map[string]string),
+		resolved:      make(map[string]string),
+	}
+	var strs []interpolatedString
+	t.walk(v, nil, nil, make(map[uintptr]bool), &strs)
+	for _, str := range strs {
+		s, err := t.resolve(str.path)
+		if err != nil {
+			return err
+		}
+		str.store(reflect.ValueOf(s).Convert(str.typ))
+	}
+	return nil
+}
+
+// walk indexes every value of v by path and appends to strs the strings holding references.
+// store replaces v where it lives, it is nil when v cannot be replaced.
+func (t *interpolationTree) walk(v reflect.Value, path fieldPath, store func(reflect.Value), seen map[uintptr]bool, strs *[]interpolatedString) {
+	key := path.String()
+	t.values[key] = v
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return
+		}
+		seen[v.Pointer()] = true
+		defer delete(seen, v.Pointer())
+		t.walk(v.Elem(), path, v.Elem().Set, seen, strs)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if v.CanSet() {
+			store = v.Set
+		}
+		t.walk(v.Elem(), path, store, seen, strs)
+	case reflect.String:
+		t.raw[key] = v.String()
+		if store != nil && strings.Contains(v.String(), "${") {
+			*strs = append(*strs, interpolatedString{key, v.Type(), store})
+		}
+	case reflect.Struct:
+		v, store = addressable(v, store)
+		plan := planOf(v.Type())
+		for i := range plan.fields {
+			field := &plan.fields[i]
+			if !field.exported {
+				continue
+			}
+			t.walk(v.Field(i), field.path(path), elemStore(v.Field(i), v, store), seen, strs)
+		}
+	case reflect.Map:
+		for _, k := range sortedMapKeys(v) {
+			k := k
+			t.walk(v.MapIndex(k), path.key(k), func(x reflect.Value) {
+				v.SetMapIndex(k, x)
+			}, seen, strs)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Array {
+			v, store = addressable(v, store)
+		}
+		for i, n := 0, v.Len(); i < n; i++ {
+			t.walk(v.Index(i), path.index(i), elemStore(v.Index(i), v, store), seen, strs)
+		}
+	}
+}
+
+// addressable returns a copy of v that can be modified when v cannot, along with
+// a store that writes the copy back through store.
+func addressable(v reflect.Value, store func(reflect.Value)) (reflect.Value, func(reflect.Value)) {
+	if v.CanAddr() || store == nil {
+		return v, store
+	}
+	copied := reflect.New(v.Type()).Elem()
+	copied.Set(v)
+	return copied, func(reflect.Value) { store(copied) }
+}
+
+// elemStore returns how to replace elem, a field or element of parent.
+// parentStore is called afterwards when parent is a copy.
+func elemStore(elem, parent reflect.Value, parentStore func(reflect.Value)) func(reflect.Value) {
+	if !elem.CanSet() {
+		return nil
+	}
+	if parentStore == nil {
+		return elem.Set
+	}
+	return func(x reflect.Value) {
+		elem.Set(x)
+		parentStore(parent)
+	}
+}
+
+// resolve returns the string at path with its references replaced.
+func (t *interpolationTree) resolve(path string) (string, error) {
+	if s, ok := t.resolved[path]; ok {
+		return s, nil
+	}
+	for i, p := range t.stack {
+		if p == path {
+			cycle := append(t.stack[i:len(t.stack):len(t.stack)], path)
+			return "", &PathError{path, fmt.Errorf("%w: %s", ErrReferenceCycle, strings.Join(cycle, " -> "))}
+		}
+	}
+	t.stack = append(t.stack, path)
+	s, err := t.expand(path, t.raw[path])
+	t.stack = t.stack[:len(t.stack)-1]
+	if err != nil {
+		return "", err
+	}
+	t.resolved[path] = s
+	return s, nil
+}
+
+func (t *interpolationTree) expand(path, s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i+2:], '}')
+		if end < 0 {
+			return "", &PathError{path, fmt.Errorf("%w: unterminated %q", ErrInvalidReference, s[i:])}
+		}
+		value, err := t.lookup(path, strings.TrimSpace(s[i+2:i+2+end]))
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		s = s[i+3+end:]
+	}
+}
+
+// lookup returns the value of the reference ref found in the string at path.
+func (t *interpolationTree) lookup(path, ref string) (string, error) {
+	if ref == "" {
+		return "", &PathError{path, fmt.Errorf("%w: ${}", ErrInvalidReference)}
+	}
+	if name, ok := strings.CutPrefix(ref, "env:"); ok {
+		value, ok := t.lookupEnv(name)
+		if !ok {
+			return "", &PathError{path, fmt.Errorf("%w: ${%s}", ErrMissingReference, ref)}
+		}
+		return value, nil
+	}
+	if _, ok := t.raw[ref]; ok {
+		return t.resolve(ref)
+	}
+	v, ok := t.values[ref]
+	if !ok {
+		return "", &PathError{path, fmt.Errorf("%w: ${%s}", ErrMissingReference, ref)}
+	}
+	v = unwrapInterface(v)
+	for v.Kind() == reflect.Ptr && !v.IsNil() && !v.Type().Implements(stringerType) {
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "", nil
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Chan, reflect.Func:
+		if !v.Type().Implements(stringerType) {
+			return "", &PathError{path, fmt.Errorf("%w: ${%s} is a %s", ErrInvalidReference, ref, v.Kind())}
+		}
+	}
+	return fmt.Sprint(v.Interface()), nil
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
@@ -0,0 +1,383 @@
+package merge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// MergeJSONStream writes to w the base JSON document with the overlays merged over it,
+// in order, as Merge does with WithOverwrite: objects are merged key by key and arrays
+// and scalars are replaced.
+func MergeJSONStream(w io.Writer, base io.Reader, overlays ...io.Reader) error {
+	return MergeJSONStreamOptions(w, base, overlays, WithOverwrite())
+}
+
+// MergeJSONStreamOptions is MergeJSONStream with the merge options spelled out, e.g.
+// WithAppendSlice or WithMergeKeys for arrays, or WithExclude for paths to keep from base.
+//
+// Only the overlays are decoded into memory. The base document is read token by token
+// and copied to w as it is read, except for the values the overlays replace, so that very
+// large base documents can be merged in memory proportional to the overlays. Keys added
+// by overlays are written after the keys of base, in sorted order.
+func MergeJSONStreamOptions(w io.Writer, base io.Reader, overlays []io.Reader, opts ...Option) error {
+	config := &Options{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	var (
+		overlay    interface{}
+		hasOverlay bool
+	)
+	for i, r := range overlays {
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		var next interface{}
+		if err := dec.Decode(&next); err != nil {
+			return fmt.Errorf("overlay %d: %w", i, err)
+		}
+		if !hasOverlay {
+			overlay, hasOverlay = next, true
+			continue
+		}
+		var err error
+		if overlay, err = mergeJSONValues(overlay, next, config); err != nil {
+			return fmt.Errorf("overlay %d: %w", i, err)
+		}
+	}
+
+	dec := json.NewDecoder(base)
+	dec.UseNumber()
+	out := &jsonWriter{w: bufio.NewWriter(w)}
+	if err := streamJSONValue(dec, out, overlay, hasOverlay, nil, config); err != nil {
+		return fmt.Errorf("base: %w", err)
+	}
+	out.raw("\n")
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+// mergeJSONValues merges two decoded overlays, the way they would be merged into base.
+func mergeJSONValues(dst, src interface{}, config *Options) (interface{}, error) {
+	dstMap, dstOk := dst.(map[string]interface{})
+	srcMap, srcOk := src.(map[string]interface{})
+	if dstOk && srcOk {
+		err := deepMerge(reflect.ValueOf(&dstMap).Elem(), reflect.ValueOf(srcMap), make(map[uintptr]*visit), 0, nil, config)
+		return dstMap, err
+	}
+	if config.overwrite && (!isEmptyJSON(src) || config.overwriteWithEmptyValue) || isEmptyJSON(dst) {
+		return src, nil
+	}
+	return dst, nil
+}
+
+// streamJSONValue copies the next value of dec to out, merging overlay into it.
+func streamJSONValue(dec *json.Decoder, out *jsonWriter, overlay interface{}, hasOverlay bool, path fieldPath, config *Options) error {
+	mode := config.filter(path)
+	if !hasOverlay || mode == filterSkip {
+		return copyJSONValue(dec, out)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		if fields, ok := overlay.(map[string]interface{}); ok {
+			return streamJSONObject(dec, out, fields, path, config)
+		}
+	case json.Delim('['):
+		if items, ok := overlay.([]interface{}); ok && mode == filterAllow {
+			return streamJSONArray(dec, out, items, path, config)
+		}
+	}
+
+	var empty bool
+	if delim, ok := tok.(json.Delim); ok {
+		empty = !dec.More()
+		if mode != filterAllow || !config.overwrite && !empty || isEmptyJSON(overlay) && !config.overwriteWithEmptyValue {
+			return copyJSONRest(dec, out, delim)
+		}
+		if err = skipJSONRest(dec); err != nil {
+			return err
+		}
+	} else {
+		empty = isEmptyJSON(tok)
+		if mode != filterAllow || !config.overwrite && !empty || isEmptyJSON(overlay) && !config.overwriteWithEmptyValue {
+			out.value(tok)
+			return out.err
+		}
+	}
+	config.written(path)
+	out.value(overlay)
+	return out.err
+}
+
+func streamJSONObject(dec *json.Decoder, out *jsonWriter, overlay map[string]interface{}, path fieldPath, config *Options) error {
+	out.raw("{")
+	seen := make(map[string]bool, len(overlay))
+	for first := true; dec.More(); first = false {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if !first {
+			out.raw(",")
+		}
+		out.value(key)
+		out.raw(":")
+		value, ok := overlay[key]
+		seen[key] = true
+		if err = streamJSONValue(dec, out, value, ok, path.child(key), config); err != nil {
+			return err
+		}
+		if out.err != nil {
+			return out.err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(overlay))
+	for key := range overlay {
+		if !seen[key] && config.filter(path.child(key)) == filterAllow {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 || len(seen) > 0 {
+			out.raw(",")
+		}
+		out.value(key)
+		out.raw(":")
+		out.value(overlay[key])
+		config.written(path.child(key))
+	}
+	out.raw("}")
+	return out.err
+}
+
+func streamJSONArray(dec *json.Decoder, out *jsonWriter, overlay []interface{}, path fieldPath, config *Options) error {
+	if !dec.More() {
+		_, err := dec.Token()
+		if len(overlay) == 0 && !config.overwriteWithEmptyValue && !config.overwriteSliceWithEmptyValue {
+			out.raw("[]")
+		} else {
+			out.value(overlay)
+			config.written(path)
+		}
+		return err
+	}
+	name, pending := jsonArrayKeys(overlay, config.mergeKeys)
+	if name == "" && !config.appendSlice {
+		if !config.overwrite || len(overlay) == 0 && !config.overwriteWithEmptyValue && !config.overwriteSliceWithEmptyValue {
+			return copyJSONRest(dec, out, json.Delim('['))
+		}
+		if err := skipJSONRest(dec); err != nil {
+			return err
+		}
+		out.value(overlay)
+		config.written(path)
+		return out.err
+	}
+
+	// Keyed arrays are merged one base element at a time.
+	out.raw("[")
+	n := 0
+	for ; dec.More(); n++ {
+		if n > 0 {
+			out.raw(",")
+		}
+		if name == "" {
+			if err := copyJSONValue(dec, out); err != nil {
+				return err
+			}
+			continue
+		}
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		var fields map[string]interface{}
+		src, found := interface{}(nil), false
+		if json.Unmarshal(item, &fields) == nil {
+			if key, ok := jsonKey(fields, name); ok {
+				if src, found = pending[key]; found {
+					delete(pending, key)
+				}
+			}
+		}
+		itemDec := json.NewDecoder(bytes.NewReader(item))
+		itemDec.UseNumber()
+		if err := streamJSONValue(itemDec, out, src, found, path.index(n), config); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for _, item := range overlay {
+		if name != "" {
+			key, _ := jsonKey(item.(map[string]interface{}), name)
+			if _, ok := pending[key]; !ok {
+				continue
+			}
+			delete(pending, key)
+		}
+		if n > 0 {
+			out.raw(",")
+		}
+		out.value(item)
+		config.written(path.index(n))
+		n++
+	}
+	out.raw("]")
+	return out.err
+}
+
+// jsonArrayKeys returns the first merge key every item of overlay has and the items by key.
+func jsonArrayKeys(overlay []interface{}, names []string) (string, map[string]interface{}) {
+next:
+	for _, name := range names {
+		byKey := make(map[string]interface{}, len(overlay))
+		for _, item := range overlay {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue next
+			}
+			key, ok := jsonKey(fields, name)
+			if !ok {
+				continue next
+			}
+			if _, dup := byKey[key]; !dup {
+				byKey[key] = item
+			}
+		}
+		return name, byKey
+	}
+	return "", nil
+}
+
+func jsonKey(fields map[string]interface{}, name string) (string, bool) {
+	value, ok := fields[name]
+	if !ok || isEmptyJSON(value) {
+		return "", false
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+func isEmptyJSON(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case json.Number:
+		f, err := v.Float64()
+		return err == nil && f == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// copyJSONValue copies the next value of dec to out without decoding it as a whole.
+func copyJSONValue(dec *json.Decoder, out *jsonWriter) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); ok {
+		return copyJSONRest(dec, out, delim)
+	}
+	out.value(tok)
+	return out.err
+}
+
+// copyJSONRest copies the object or array opened by delim.
+func copyJSONRest(dec *json.Decoder, out *jsonWriter, delim json.Delim) error {
+	out.raw(string(delim))
+	for first := true; dec.More(); first = false {
+		if !first {
+			out.raw(",")
+		}
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			out.value(key)
+			out.raw(":")
+		}
+		if err := copyJSONValue(dec, out); err != nil {
+			return err
+		}
+	}
+	end, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	out.raw(string(end.(json.Delim)))
+	return out.err
+}
+
+// skipJSONRest discards the rest of the object or array just opened.
+func skipJSONRest(dec *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// jsonWriter writes compact JSON and keeps the first error.
+type jsonWriter struct {
+	w   *bufio.Writer
+	buf bytes.Buffer
+	err error
+}
+
+func (jw *jsonWriter) raw(s string) {
+	if jw.err == nil {
+		_, jw.err = jw.w.WriteString(s)
+	}
+}
+
+func (jw *jsonWriter) value(v interface{}) {
+	if jw.err != nil {
+		return
+	}
+	jw.buf.Reset()
+	enc := json.NewEncoder(&jw.buf)
+	enc.SetEscapeHTML(false)
+	if jw.err = enc.Encode(v); jw.err == nil {
+		_, jw.err = jw.w.Write(bytes.TrimSuffix(jw.buf.Bytes(), []byte("\n")))
+	}
+}
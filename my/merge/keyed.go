@@ -0,0 +1,94 @@
+package merge
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+// mergeKeyedSlice merges src into dst matching their elements by the value of the first
+// WithMergeKeys key every element of both has: matching elements are merged, the others
+// are appended. ok is false when the slices are not keyed and must be merged as a whole.
+func (config *Options) mergeKeyedSlice(dst, src reflect.Value, visited map[uintptr]*visit, depth int, path fieldPath) (merged reflect.Value, ok bool, err error) {
+	if len(config.mergeKeys) == 0 || dst.Len() == 0 || src.Len() == 0 || dst.Type() != src.Type() {
+		return reflect.Value{}, false, nil
+	}
+	for _, name := range config.mergeKeys {
+		dstKeys, dstOk := sliceKeys(dst, name, config.lenientKeys)
+		srcKeys, srcOk := sliceKeys(src, name, config.lenientKeys)
+		if !dstOk || !srcOk {
+			continue
+		}
+
+		index := make(map[string]int, len(dstKeys))
+		for i, key := range dstKeys {
+			if _, dup := index[key]; !dup {
+				index[key] = i
+			}
+		}
+		config.trace(path, "merge by key", dst, src, slog.String("key", name))
+		merged = reflect.MakeSlice(dst.Type(), dst.Len(), dst.Len()+src.Len())
+		reflect.Copy(merged, dst)
+		for j, key := range srcKeys {
+			i, found := index[key]
+			if !found {
+				if err = config.checkAppend(path, merged.Len()+1, reflect.Value{}); err != nil {
+					return reflect.Value{}, false, err
+				}
+				if err = config.write(config.indexPath(path, merged.Len()), ActionSet, reflect.Value{}, src.Index(j), func(v reflect.Value) reflect.Value {
+					merged = reflect.Append(merged, v)
+					index[key] = merged.Len() - 1
+					return v
+				}); err != nil {
+					return reflect.Value{}, false, err
+				}
+				continue
+			}
+			dstElem, srcElem := keyedElement(merged.Index(i)), keyedElement(src.Index(j))
+			elemPath := config.indexPath(path, i)
+			if err = config.checkImmutable(dstElem, srcElem, elemPath, nil); err != nil {
+				return reflect.Value{}, false, err
+			}
+			if err = deepMerge(dstElem, srcElem, visited, depth+1, elemPath, config); err != nil {
+				return reflect.Value{}, false, err
+			}
+		}
+		return merged, true, nil
+	}
+	return reflect.Value{}, false, nil
+}
+
+// sliceKeys returns the value of the name key of every element of s, if they all have one.
+// Struct elements find the field of name as Map does, ignoring case if lenient.
+func sliceKeys(s reflect.Value, name string, lenient bool) ([]string, bool) {
+	keys := make([]string, s.Len())
+	for i := range keys {
+		elem := keyedElement(s.Index(i))
+		var value reflect.Value
+		switch elem.Kind() {
+		case reflect.Map:
+			if elem.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			value = elem.MapIndex(reflect.ValueOf(name).Convert(elem.Type().Key()))
+		case reflect.Struct:
+			if field, _, ok := planOf(elem.Type()).fieldByKey(elem.Type(), name, lenient); ok {
+				value = elem.FieldByIndex(field.Index)
+			}
+		}
+		value = unwrapInterface(value)
+		if !value.IsValid() || isEmptyValue(value) || !value.CanInterface() {
+			return nil, false
+		}
+		keys[i] = fmt.Sprint(value.Interface())
+	}
+	return keys, true
+}
+
+// keyedElement returns the map or struct held by a slice element.
+func keyedElement(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
@@ -0,0 +1,230 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+)
+
+var (
+	// ErrOverflow is returned when a leaf strategy overflows the type of its values.
+	ErrOverflow = errors.New("numeric overflow")
+	// ErrNotNumber is returned when a numeric leaf strategy applies to a value that is not a number.
+	ErrNotNumber = errors.New("value is not a number")
+)
+
+// LeafStrategy combines two leaves, neither of them empty and both of the same type,
+// into the value merge writes to dst. Leaves where dst or src is empty are merged as
+// usual, e.g. the max of an unset dst and 3 is 3.
+type LeafStrategy interface {
+	MergeLeaf(dst, src reflect.Value) (reflect.Value, error)
+}
+
+type numberStrategy int
+
+const (
+	numberSum numberStrategy = iota
+	numberMax
+	numberMin
+	numberAverage
+)
+
+// The numeric leaf strategies work on every integer and float kind, time.Duration
+// included, and return an error wrapping ErrOverflow rather than wrapping around.
+var (
+	// Sum adds src to dst.
+	Sum LeafStrategy = numberSum
+	// Max keeps the largest of dst and src.
+	Max LeafStrategy = numberMax
+	// Min keeps the smallest of dst and src.
+	Min LeafStrategy = numberMin
+	// Average keeps the mean of dst and src, rounded towards zero for integers. Over
+	// several merges, it weighs the last src as much as all the values before it.
+	Average LeafStrategy = numberAverage
+)
+
+// leafStrategies are the strategies of the `merge:"leaf=<name>"` tag taking no
+// argument, see tagLeafStrategy for the others.
+var leafStrategies = map[string]LeafStrategy{
+	"sum":     Sum,
+	"max":     Max,
+	"min":     Min,
+	"avg":     Average,
+	"average": Average,
+}
+
+func (s numberStrategy) String() string {
+	return [...]string{"sum", "max", "min", "average"}[s]
+}
+
+func (s numberStrategy) MergeLeaf(dst, src reflect.Value) (reflect.Value, error) {
+	ret := reflect.New(dst.Type()).Elem()
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		a, b := dst.Int(), src.Int()
+		var r int64
+		switch s {
+		case numberSum:
+			r = a + b
+			if (b > 0 && r < a) || (b < 0 && r > a) || ret.OverflowInt(r) {
+				return reflect.Value{}, fmt.Errorf("%w: %v + %v", ErrOverflow, dst.Interface(), src.Interface())
+			}
+		case numberMax:
+			r = a
+			if b > a {
+				r = b
+			}
+		case numberMin:
+			r = a
+			if b < a {
+				r = b
+			}
+		case numberAverage:
+			// The floor of the mean without overflowing, moved up to round towards zero
+			// when it is negative and not whole.
+			r = (a & b) + (a^b)>>1
+			if r < 0 && (a^b)&1 != 0 {
+				r++
+			}
+		}
+		ret.SetInt(r)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		a, b := dst.Uint(), src.Uint()
+		var r uint64
+		switch s {
+		case numberSum:
+			r = a + b
+			if r < a || ret.OverflowUint(r) {
+				return reflect.Value{}, fmt.Errorf("%w: %v + %v", ErrOverflow, dst.Interface(), src.Interface())
+			}
+		case numberMax:
+			r = a
+			if b > a {
+				r = b
+			}
+		case numberMin:
+			r = a
+			if b < a {
+				r = b
+			}
+		case numberAverage:
+			r = a/2 + b/2 + (a%2+b%2)/2
+		}
+		ret.SetUint(r)
+	case reflect.Float32, reflect.Float64:
+		a, b := dst.Float(), src.Float()
+		var r float64
+		switch s {
+		case numberSum:
+			r = a + b
+			if (math.IsInf(r, 0) && !math.IsInf(a, 0) && !math.IsInf(b, 0)) || ret.OverflowFloat(r) {
+				return reflect.Value{}, fmt.Errorf("%w: %v + %v", ErrOverflow, dst.Interface(), src.Interface())
+			}
+		case numberMax:
+			r = math.Max(a, b)
+		case numberMin:
+			r = math.Min(a, b)
+		case numberAverage:
+			r = a/2 + b/2
+		}
+		ret.SetFloat(r)
+	default:
+		return reflect.Value{}, fmt.Errorf("%w: %s", ErrNotNumber, dst.Type())
+	}
+	return ret, nil
+}
+
+// WithLeafStrategy merges the leaves matched by patterns with strategy, like the
+// `merge:"leaf=<name>"` tag, where name is sum, max, min, avg, concat, append,
+// prepend or template.
+func WithLeafStrategy(strategy LeafStrategy, patterns ...string) Option {
+	return func(config *Options) {
+		for _, pattern := range compilePatterns(patterns) {
+			config.leafPaths = append(config.leafPaths, pathLeafStrategy{pattern, strategy})
+		}
+	}
+}
+
+// WithTypeLeafStrategy merges every leaf of type typ with strategy, unless a path
+// or a tag selects another one.
+func WithTypeLeafStrategy(typ reflect.Type, strategy LeafStrategy) Option {
+	return func(config *Options) {
+		if config.leafTypes == nil {
+			config.leafTypes = make(map[reflect.Type]LeafStrategy)
+		}
+		config.leafTypes[typ] = strategy
+	}
+}
+
+type pathLeafStrategy struct {
+	pattern  pathPattern
+	strategy LeafStrategy
+}
+
+// leafStrategy returns the strategy for the leaf at path: the last WithLeafStrategy
+// matching it, the leaf directive of its tag or the WithTypeLeafStrategy of its type.
+func (config *Options) leafStrategy(path fieldPath, tag tagOptions, typ reflect.Type) (LeafStrategy, bool, error) {
+	for i := len(config.leafPaths) - 1; i >= 0; i-- {
+		if config.leafPaths[i].pattern.match(path) {
+			return config.leafPaths[i].strategy, true, nil
+		}
+	}
+	if tag.has("leaf") {
+		strategy, err := tagLeafStrategy(tag)
+		if err != nil {
+			return nil, false, &PathError{path.String(), err}
+		}
+		return strategy, true, nil
+	}
+	strategy, ok := config.leafTypes[typ]
+	return strategy, ok, nil
+}
+
+// mergeLeaf writes with store the result of strategy on the leaves dst and src at
+// path. It reports false, leaving them to be merged as usual, when one of them is empty.
+func (config *Options) mergeLeaf(path fieldPath, dst, src reflect.Value, strategy LeafStrategy, store func(v reflect.Value) reflect.Value) (bool, error) {
+	dstLeaf, srcLeaf := unwrapInterface(dst), unwrapInterface(src)
+	if isEmptyValue(dstLeaf) || isEmptyValue(srcLeaf) {
+		return false, nil
+	}
+	if dstLeaf.Type() != srcLeaf.Type() {
+		return true, &PathError{path.String(), fmt.Errorf("%w: %s and %s", ErrDifferentArgumentsTypes, dstLeaf.Type(), srcLeaf.Type())}
+	}
+	result, err := strategy.MergeLeaf(dstLeaf, srcLeaf)
+	if err != nil {
+		return true, &PathError{path.String(), err}
+	}
+	config.trace(path, "leaf", dst, src, slog.String("strategy", fmt.Sprint(strategy)))
+	return true, config.write(path, ActionSet, dst, result, store)
+}
+
+// combines reports whether set operations or leaf strategies may apply to paths
+// without a tag.
+func (config *Options) combines() bool {
+	return len(config.setOps) > 0 || len(config.leafPaths) > 0 || len(config.leafTypes) > 0
+}
+
+// mergeCombined merges src into dst at path with the set operation or the leaf strategy
+// applying to them, if any, reporting whether one did. The result is written with store.
+func (config *Options) mergeCombined(path fieldPath, dst, src reflect.Value, tag tagOptions, store func(v reflect.Value) reflect.Value) (bool, error) {
+	if tag == nil && !config.combines() {
+		return false, nil
+	}
+	if op, ok, err := config.setOp(path, tag); err != nil {
+		return true, err
+	} else if ok {
+		return true, config.mergeSet(path, dst, src, op, store)
+	}
+	leaf := unwrapInterface(src)
+	if !leaf.IsValid() {
+		return false, nil
+	}
+	if strategy, ok, err := config.leafStrategy(path, tag, leaf.Type()); err != nil {
+		return true, err
+	} else if ok {
+		return config.mergeLeaf(path, dst, src, strategy, store)
+	}
+	return false, nil
+}
@@ -0,0 +1,134 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// ErrNotString is returned when a string leaf strategy applies to a value that is not a string.
+var ErrNotString = errors.New("value is not a string")
+
+// stringStrategy is a LeafStrategy on strings, and types of kind string.
+type stringStrategy struct {
+	name string
+	fn   func(dst, src string) (string, error)
+}
+
+func (s *stringStrategy) String() string {
+	return s.name
+}
+
+func (s *stringStrategy) MergeLeaf(dst, src reflect.Value) (reflect.Value, error) {
+	if dst.Kind() != reflect.String {
+		return reflect.Value{}, fmt.Errorf("%w: %s", ErrNotString, dst.Type())
+	}
+	result, err := s.fn(dst.String(), src.String())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	ret := reflect.New(dst.Type()).Elem()
+	ret.SetString(result)
+	return ret, nil
+}
+
+// Concat joins dst and src with sep, e.g. "a,b" and "c" into "a,b,c".
+func Concat(sep string) LeafStrategy {
+	return &stringStrategy{"concat", func(dst, src string) (string, error) {
+		return dst + sep + src, nil
+	}}
+}
+
+// AppendIfMissing appends src to dst, a list of items separated by sep, unless
+// it is one of them already: "/usr/bin:/bin" and "/opt/bin" give "/usr/bin:/bin:/opt/bin".
+// Without a separator, src is appended unless dst contains it.
+func AppendIfMissing(sep string) LeafStrategy {
+	return &stringStrategy{"append", func(dst, src string) (string, error) {
+		if containsItem(dst, src, sep) {
+			return dst, nil
+		}
+		return dst + sep + src, nil
+	}}
+}
+
+// PrependIfMissing prepends src to dst, see AppendIfMissing.
+func PrependIfMissing(sep string) LeafStrategy {
+	return &stringStrategy{"prepend", func(dst, src string) (string, error) {
+		if containsItem(dst, src, sep) {
+			return dst, nil
+		}
+		return src + sep + dst, nil
+	}}
+}
+
+// containsItem is copied by other/merge/leaf.go: change them together.
+func containsItem(list, item, sep string) bool {
+	if sep == "" {
+		return strings.Contains(list, item)
+	}
+	for _, existing := range strings.Split(list, sep) {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
+
+// Template computes the result from the text/template text, executed with the dst
+// and src values as .dst and .src, e.g. "{{.dst}},{{.src}}". An invalid template is
+// reported by the merges using it.
+func Template(text string) LeafStrategy {
+	tmpl, err := template.New("merge").Option("missingkey=error").Parse(text)
+	return &stringStrategy{"template", func(dst, src string) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, map[string]string{"dst": dst, "src": src}); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}}
+}
+
+// tagStrategies caches the strategies built from tags, by directive.
+var tagStrategies sync.Map
+
+// tagLeafStrategy returns the strategy of a `merge:"leaf=<name>"` tag. The string
+// strategies concat, append and prepend take their separator from a sep directive,
+// a comma by default: `merge:"leaf=append,sep=:"`. template takes its text from a
+// template directive: `merge:"leaf=template,template={{.src}}/{{.dst}}"`. Either is
+// single quoted to hold commas or spaces: `merge:"leaf=concat,sep=' '"` or
+// `merge:"leaf=template,template='{{.dst}},{{.src}}'"`.
+func tagLeafStrategy(tag tagOptions) (LeafStrategy, error) {
+	name := tag["leaf"]
+	if strategy, ok := leafStrategies[name]; ok {
+		return strategy, nil
+	}
+	sep, ok := tag["sep"]
+	if !ok {
+		sep = ","
+	}
+	key := name + "\x00" + sep + "\x00" + tag["template"]
+	if strategy, ok := tagStrategies.Load(key); ok {
+		return strategy.(LeafStrategy), nil
+	}
+	var strategy LeafStrategy
+	switch name {
+	case "concat":
+		strategy = Concat(sep)
+	case "append":
+		strategy = AppendIfMissing(sep)
+	case "prepend":
+		strategy = PrependIfMissing(sep)
+	case "template":
+		strategy = Template(tag["template"])
+	default:
+		return nil, fmt.Errorf("unknown leaf strategy %q", name)
+	}
+	tagStrategies.Store(key, strategy)
+	return strategy, nil
+}
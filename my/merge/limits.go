@@ -0,0 +1,222 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+// ErrLimitExceeded is wrapped by the *LimitError returned when a merge goes over
+// one of the limits set by WithMaxElements, WithMaxSliceLen, WithMaxMapKeys or WithMaxBytes.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError reports the limit a merge went over and where.
+type LimitError struct {
+	Path string
+	// Limit is "elements", "slice length", "map keys" or "bytes".
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("%s: more than %d %s", ErrLimitExceeded, e.Max, e.Limit)
+	if e.Path == "" {
+		return msg
+	}
+	return e.Path + ": " + msg
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// limits are the caps on the work of a single merge, zero meaning no cap. Their
+// usage is shared by the goroutines of WithConcurrency.
+type limits struct {
+	maxElements, maxSliceLen, maxMapKeys, maxBytes int64
+
+	elements, bytes int64
+}
+
+// WithMaxElements will make merge fail after visiting more than n values.
+func WithMaxElements(n int64) Option {
+	return func(config *Options) {
+		config.getLimits().maxElements = n
+	}
+}
+
+// WithMaxSliceLen will make merge fail instead of writing a slice longer than n
+// elements, whether it comes from src or grows by appending with WithAppendSlice.
+func WithMaxSliceLen(n int64) Option {
+	return func(config *Options) {
+		config.getLimits().maxSliceLen = n
+	}
+}
+
+// WithMaxMapKeys will make merge fail instead of writing a map with more than n keys,
+// or adding keys to a map of n keys.
+func WithMaxMapKeys(n int64) Option {
+	return func(config *Options) {
+		config.getLimits().maxMapKeys = n
+	}
+}
+
+// WithMaxBytes will make merge fail once the values it copies into dst add up to more
+// than n bytes, as estimated from their in-memory size: strings, slices and maps count
+// their contents.
+func WithMaxBytes(n int64) Option {
+	return func(config *Options) {
+		config.getLimits().maxBytes = n
+	}
+}
+
+func (config *Options) getLimits() *limits {
+	if config.limits == nil {
+		config.limits = &limits{}
+	}
+	return config.limits
+}
+
+// countElement counts one more visited value against WithMaxElements.
+func (config *Options) countElement(path fieldPath) error {
+	l := config.limits
+	if l == nil || l.maxElements == 0 {
+		return nil
+	}
+	if atomic.AddInt64(&l.elements, 1) > l.maxElements {
+		return &LimitError{path.String(), "elements", l.maxElements}
+	}
+	return nil
+}
+
+// checkMapKeys checks the number of keys a map merged key by key is about to have.
+func (config *Options) checkMapKeys(path fieldPath, n int) error {
+	if l := config.limits; l != nil && l.maxMapKeys > 0 && int64(n) > l.maxMapKeys {
+		return &LimitError{path.String(), "map keys", l.maxMapKeys}
+	}
+	return nil
+}
+
+// checkAppend checks the limits before v is appended to a slice at path, making it n long.
+func (config *Options) checkAppend(path fieldPath, n int, v reflect.Value) error {
+	if l := config.limits; l != nil && l.maxSliceLen > 0 && int64(n) > l.maxSliceLen {
+		return &LimitError{path.String(), "slice length", l.maxSliceLen}
+	}
+	return config.checkWrite(path, v)
+}
+
+// checkWrite checks the limits before v is copied into dst at path: the length of
+// the slices and maps inside v, and the bytes copied so far.
+func (config *Options) checkWrite(path fieldPath, v reflect.Value) error {
+	l := config.limits
+	if l == nil || l.maxSliceLen == 0 && l.maxMapKeys == 0 && l.maxBytes == 0 {
+		return nil
+	}
+	size, err := l.walk(path, v, make(map[uintptr]bool), config.deterministicOrder)
+	if err != nil {
+		return err
+	}
+	if l.maxBytes > 0 && atomic.AddInt64(&l.bytes, size) > l.maxBytes {
+		return &LimitError{path.String(), "bytes", l.maxBytes}
+	}
+	return nil
+}
+
+// walk returns the size of v in bytes, checking the length of its slices and maps.
+func (l *limits) walk(path fieldPath, v reflect.Value, seen map[uintptr]bool, sorted bool) (size int64, err error) {
+	if !v.IsValid() {
+		return 0, nil
+	}
+	size = int64(v.Type().Size())
+	switch v.Kind() {
+	case reflect.String:
+		size += int64(v.Len())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return size, nil
+			}
+			if l.maxSliceLen > 0 && int64(v.Len()) > l.maxSliceLen {
+				return 0, &LimitError{path.String(), "slice length", l.maxSliceLen}
+			}
+			size += int64(v.Len()) * int64(v.Type().Elem().Size())
+		}
+		if !hasIndirections(v.Type().Elem()) {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			n, err := l.walk(path.index(i), v.Index(i), seen, sorted)
+			if err != nil {
+				return 0, err
+			}
+			size += n - int64(v.Type().Elem().Size())
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return size, nil
+		}
+		if l.maxMapKeys > 0 && int64(v.Len()) > l.maxMapKeys {
+			return 0, &LimitError{path.String(), "map keys", l.maxMapKeys}
+		}
+		keys := v.MapKeys()
+		if sorted {
+			keys = sortedMapKeys(v)
+		}
+		for _, key := range keys {
+			k, err := l.walk(path, key, seen, sorted)
+			if err != nil {
+				return 0, err
+			}
+			e, err := l.walk(path.key(key), v.MapIndex(key), seen, sorted)
+			if err != nil {
+				return 0, err
+			}
+			size += k + e
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return size, nil
+		}
+		if v.Kind() == reflect.Ptr {
+			if seen[v.Pointer()] {
+				return size, nil
+			}
+			seen[v.Pointer()] = true
+		}
+		n, err := l.walk(path, v.Elem(), seen, sorted)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hasIndirections(v.Type().Field(i).Type) {
+				continue
+			}
+			n, err := l.walk(path, v.Field(i), seen, sorted)
+			if err != nil {
+				return 0, err
+			}
+			size += n - int64(v.Type().Field(i).Type.Size())
+		}
+	}
+	return size, nil
+}
+
+// hasIndirections reports whether values of typ may refer to more memory than their size.
+func hasIndirections(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		return true
+	case reflect.Array:
+		return hasIndirections(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if hasIndirections(typ.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+// Package loader reads layered configuration files, JSON or YAML, and merges
+// them in order into a single value with merge.Map and merge.Merge.
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/cloudlibraries/merge"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown file format")
+	ErrNotAnObject   = errors.New("top level value must be an object")
+	ErrTrailingData  = errors.New("unexpected data after the top level value")
+)
+
+// Format is the encoding of a configuration file.
+type Format int
+
+const (
+	// FormatAuto picks the format from the file extension, YAML unless it is ".json".
+	FormatAuto Format = iota
+	FormatJSON
+	FormatYAML
+)
+
+// FormatOf returns the format of path guessed from its extension.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatYAML
+	}
+}
+
+// File is one layer of configuration.
+type File struct {
+	Path   string
+	Format Format
+	// Options are given to merge after the options of the Loader, so they can
+	// override its strategy for this file only.
+	Options []merge.Option
+}
+
+// FileError reports the file a loading error comes from.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Loader merges configuration files in the order they were added.
+type Loader struct {
+	files      []File
+	opts       []merge.Option
+	required   []string
+	validate   bool
+	provenance *merge.Provenance
+}
+
+// New returns a Loader merging every file with opts.
+func New(opts ...merge.Option) *Loader {
+	return &Loader{opts: opts}
+}
+
+// Add appends the files at paths, merged with the options of the Loader.
+func (l *Loader) Add(paths ...string) *Loader {
+	for _, path := range paths {
+		l.files = append(l.files, File{Path: path})
+	}
+	return l
+}
+
+// AddFile appends a file with its own format and options.
+func (l *Loader) AddFile(files ...File) *Loader {
+	l.files = append(l.files, files...)
+	return l
+}
+
+// Opt appends options given to merge for every file.
+func (l *Loader) Opt(opts ...merge.Option) *Loader {
+	l.opts = append(l.opts, opts...)
+	return l
+}
+
+// Require checks, once every file is merged, the fields tagged `merge:"required"`
+// and the values matched by paths, see merge.CheckRequired.
+func (l *Loader) Require(paths ...string) *Loader {
+	l.validate = true
+	l.required = append(l.required, paths...)
+	return l
+}
+
+// Provenance records in prov which file, and which line of it, every value comes from.
+func (l *Loader) Provenance(prov *merge.Provenance) *Loader {
+	l.provenance = prov
+	return l
+}
+
+// Load merges every file in order into dst, a pointer to a struct or to a map[string]interface{}.
+func (l *Loader) Load(dst interface{}) error {
+	for _, file := range l.files {
+		tree, node, err := ReadFile(file)
+		if err != nil {
+			return err
+		}
+		opts := l.options(file)
+		if l.provenance != nil {
+			opts = append(opts, merge.WithProvenance(l.provenance, file.Path), merge.WithSourceNode(file.Path, node))
+		}
+		if err := mergeTree(dst, tree, opts...); err != nil {
+			return &FileError{file.Path, err}
+		}
+	}
+	if l.validate {
+		return merge.CheckRequired(dst, l.required...)
+	}
+	return nil
+}
+
+// Load merges the files at paths in order into dst with opts.
+func Load(dst interface{}, paths []string, opts ...merge.Option) error {
+	return New(opts...).Add(paths...).Load(dst)
+}
+
+// options returns the options file is merged with, by Load and by the layers of Watch:
+// merge.WithCoercion, so that decoded values take the type of their field, e.g. JSON
+// numbers ints and "30s" durations, and merge.WithLenientKeys, so that keys such as
+// pool_size or the names of yaml and json tags find their field, then the options of
+// the Loader and its own.
+func (l *Loader) options(file File) []merge.Option {
+	return append(append([]merge.Option{merge.WithCoercion(), merge.WithLenientKeys()}, l.opts...), file.Options...)
+}
+
+// mergeTree maps tree onto dst if it is a struct and merges it otherwise, as
+// merge.Watch does with layers.
+func mergeTree(dst interface{}, tree map[string]interface{}, opts ...merge.Option) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		return merge.Map(dst, tree, opts...)
+	}
+	return merge.Merge(dst, tree, opts...)
+}
+
+// ReadFile reads and decodes a file into a normalized tree, see Normalize.
+// The YAML node of the file is returned along with it for line numbers, it is
+// nil when the file could not be parsed as YAML.
+func ReadFile(file File) (map[string]interface{}, *yaml.Node, error) {
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return nil, nil, &FileError{file.Path, err}
+	}
+	format := file.Format
+	if format == FormatAuto {
+		format = FormatOf(file.Path)
+	}
+	tree, node, err := Decode(data, format)
+	if err != nil {
+		return nil, nil, &FileError{file.Path, err}
+	}
+	return tree, node, nil
+}
+
+// Decode decodes data, JSON with encoding/json or YAML with yaml.v3, into a normalized tree.
+func Decode(data []byte, format Format) (map[string]interface{}, *yaml.Node, error) {
+	var (
+		v    interface{}
+		node yaml.Node
+	)
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			if err == nil {
+				err = fmt.Errorf("%w at offset %d", ErrTrailingData, decoder.InputOffset())
+			}
+			return nil, nil, err
+		}
+		// JSON is YAML as far as line numbers are concerned.
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			node = yaml.Node{}
+		}
+	case FormatYAML, FormatAuto:
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, nil, err
+		}
+		if err := node.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("%w: %d", ErrUnknownFormat, format)
+	}
+
+	if v == nil {
+		// An empty file is an empty layer.
+		return map[string]interface{}{}, &node, nil
+	}
+	tree, ok := Normalize(v).(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("%w, found %T", ErrNotAnObject, v)
+	}
+	return tree, &node, nil
+}
+
+// Normalize converts decoded JSON and YAML values into a common tree: every map
+// becomes a map[string]interface{}, including the map[interface{}]interface{} of
+// YAML, every sequence a []interface{} and every json.Number an int or a float64.
+func Normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = Normalize(value)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = Normalize(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = Normalize(value)
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			if int64(int(i)) == i {
+				return int(i)
+			}
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// Layers returns the files of the Loader as layers for merge.Watch, each decoded
+// in its format and merged with the same options as by Load.
+func (l *Loader) Layers() []merge.Layer {
+	layers := make([]merge.Layer, 0, len(l.files))
+	for _, file := range l.files {
+		format := file.Format
+		if format == FormatAuto {
+			format = FormatOf(file.Path)
+		}
+		layers = append(layers, merge.Layer{
+			Path: file.Path,
+			Decode: func(data []byte) (map[string]interface{}, error) {
+				tree, _, err := Decode(data, format)
+				return tree, err
+			},
+			Options: l.options(file),
+		})
+	}
+	return layers
+}
@@ -0,0 +1,309 @@
+package loader_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudlibraries/merge"
+	"github.com/cloudlibraries/merge/loader"
+)
+
+type pool struct {
+	Size    int
+	Timeout time.Duration
+}
+
+type config struct {
+	Name  string `merge:"required"`
+	Hosts []string
+	Pool  pool
+	Port  int64
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "name: api\nhosts: [a]\npool:\n  size: 5\n  timeout: 30s\nport: 80\n")
+	override := writeFile(t, dir, "override.json", `{"hosts": ["b"], "pool": {"size": 20}, "port": 8080}`)
+
+	var cfg config
+	err := loader.New(merge.WithOverwrite()).
+		Add(base).
+		AddFile(loader.File{Path: override, Options: []merge.Option{merge.WithAppendSlice()}}).
+		Require().
+		Load(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := config{
+		Name:  "api",
+		Hosts: []string{"a", "b"},
+		Pool:  pool{Size: 20, Timeout: 30 * time.Second},
+		Port:  8080,
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", cfg, expected)
+	}
+}
+
+func TestLoadMap(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "fields:\n  id: int\n  1: one\n")
+	override := writeFile(t, dir, "override.yml", "fields:\n  name: string\n")
+
+	dst := map[string]interface{}{}
+	if err := loader.Load(&dst, []string{base, override}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"fields": map[string]interface{}{"id": "int", "1": "one", "name": "string"},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func TestLoadErrorsNameTheFile(t *testing.T) {
+	dir := t.TempDir()
+	good := writeFile(t, dir, "good.yaml", "name: api\n")
+	bad := writeFile(t, dir, "bad.json", `{"name": `)
+
+	var cfg config
+	err := loader.Load(&cfg, []string{good, bad})
+	var fileErr *loader.FileError
+	if !errors.As(err, &fileErr) || fileErr.Path != bad {
+		t.Fatalf("expected a *FileError for %s, got %v", bad, err)
+	}
+	if !strings.HasPrefix(err.Error(), bad+": ") {
+		t.Errorf("error does not start with the file name: %v", err)
+	}
+
+	err = loader.Load(&cfg, []string{filepath.Join(dir, "missing.yaml")})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestDecodeRejectsTrailingJSON(t *testing.T) {
+	for _, data := range []string{`{"a":1}{"b":2}`, `{"a":1} garbage`, `{"a":1}]`} {
+		if _, _, err := loader.Decode([]byte(data), loader.FormatJSON); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+	if _, _, err := loader.Decode([]byte(`{"a":1}{"b":2}`), loader.FormatJSON); !errors.Is(err, loader.ErrTrailingData) {
+		t.Errorf("expected ErrTrailingData, got %v", err)
+	}
+	tree, _, err := loader.Decode([]byte("{\"a\":1}\n\n"), loader.FormatJSON)
+	if err != nil || !reflect.DeepEqual(tree, map[string]interface{}{"a": 1}) {
+		t.Errorf("unexpected result for trailing whitespace: %v, %v", tree, err)
+	}
+}
+
+func TestLoadRequired(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "port: 80\n")
+
+	var cfg config
+	err := loader.New().Add(base).Require("pool.size").Load(&cfg)
+	var missingErr *merge.MissingFieldsError
+	if !errors.As(err, &missingErr) || !reflect.DeepEqual(missingErr.Paths, []string{"name", "pool.size"}) {
+		t.Errorf("expected name and pool.size to be missing, got %v", err)
+	}
+}
+
+func TestLoadProvenance(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "name: api\npool:\n  size: 5\n")
+	override := writeFile(t, dir, "override.json", "{\n  \"pool\": {\n    \"size\": 20\n  }\n}\n")
+
+	prov := merge.NewProvenance()
+	var cfg config
+	if err := loader.New(merge.WithOverwrite()).Add(base, override).Provenance(prov).Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if origin, ok := prov.Origin("pool.size"); !ok || origin.File != override || origin.Line != 3 {
+		t.Errorf("unexpected origin for pool.size: %+v", origin)
+	}
+	if origin, ok := prov.Origin("name"); !ok || origin.File != base || origin.Line != 1 {
+		t.Errorf("unexpected origin for name: %+v", origin)
+	}
+}
+
+func TestLoadProvenanceSnakeCase(t *testing.T) {
+	type db struct {
+		PoolSize int
+		MaxConns int `yaml:"conns"`
+	}
+	type dbConfig struct {
+		DB db
+	}
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "db:\n  pool_size: 5\n  conns: 3\n")
+
+	prov := merge.NewProvenance()
+	var cfg dbConfig
+	if err := loader.New().Add(base).Provenance(prov).Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (dbConfig{DB: db{PoolSize: 5, MaxConns: 3}}); cfg != expected {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", cfg, expected)
+	}
+	for path, line := range map[string]int{"db.pool_size": 2, "dB.poolSize": 2, "db.conns": 3} {
+		if origin, ok := prov.Origin(path); !ok || origin.File != base || origin.Line != line {
+			t.Errorf("unexpected origin for %s: %+v", path, origin)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "name: api\npool:\n  size: 5\n")
+	override := writeFile(t, dir, "override.yaml", "pool:\n  size: 10\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan merge.WatchEvent[config], 1)
+	var cfg atomic.Pointer[config]
+	layers := loader.New(merge.WithOverwrite()).Add(base, override).Layers()
+	err := merge.Watch(ctx, layers, &cfg, func(ev merge.WatchEvent[config]) { events <- ev }, merge.WithPollInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Load().Pool.Size; got != 10 {
+		t.Fatalf("expected pool size 10, got %d", got)
+	}
+
+	touch := func(path, content string) {
+		writeFile(t, dir, filepath.Base(path), content)
+		// Make sure the change is seen on file systems with a coarse modification time.
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func() merge.WatchEvent[config] {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+		return merge.WatchEvent[config]{}
+	}
+
+	touch(override, "pool:\n  size: 20\n")
+	ev := next()
+	if ev.Err != nil {
+		t.Fatal(ev.Err)
+	}
+	changes := []merge.Change{{Op: merge.ChangeReplace, Path: "pool.size", Old: 10, New: 20}}
+	if !reflect.DeepEqual(ev.Changes, changes) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", ev.Changes, changes)
+	}
+	if ev.Config != cfg.Load() || cfg.Load().Pool.Size != 20 {
+		t.Errorf("expected the new configuration to be stored, got %#v", cfg.Load())
+	}
+
+	touch(override, "pool: [\n")
+	ev = next()
+	if ev.Err == nil || !strings.Contains(ev.Err.Error(), "override.yaml") {
+		t.Errorf("expected an error naming override.yaml, got %v", ev.Err)
+	}
+	if cfg.Load().Pool.Size != 20 {
+		t.Errorf("the previous configuration should be kept, got %#v", cfg.Load())
+	}
+}
+
+func TestWatchRetriesFailedPoll(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "name: api\n")
+	override := writeFile(t, dir, "override.yaml", "pool:\n  size: 10\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan merge.WatchEvent[config], 1)
+	var cfg atomic.Pointer[config]
+	layers := loader.New(merge.WithOverwrite()).Add(base, override).Layers()
+	err := merge.Watch(ctx, layers, &cfg, func(ev merge.WatchEvent[config]) { events <- ev }, merge.WithPollInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() merge.WatchEvent[config] {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+		return merge.WatchEvent[config]{}
+	}
+
+	// base changes while override cannot be read: the poll fails after reading base.
+	writeFile(t, dir, "base.yaml", "name: web\n")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(base, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(override); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(override, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(); ev.Err == nil {
+		t.Fatalf("expected an error reading override.yaml, got %#v", ev)
+	}
+
+	// override comes back unchanged, the change of base must still be seen.
+	if err := os.Remove(override); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "override.yaml", "pool:\n  size: 10\n")
+	ev := next()
+	if ev.Err != nil {
+		t.Fatal(ev.Err)
+	}
+	if got := cfg.Load(); got.Name != "web" || got.Pool.Size != 10 {
+		t.Errorf("expected the change of base.yaml to be loaded, got %#v", got)
+	}
+}
+
+func TestWatchLoadsLikeLoad(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", "name: api\npool:\n  size: 5\n  timeout: 30s\nhosts: a,b\n")
+	override := writeFile(t, dir, "override.json", `{"port": 8080, "pool": {"size": 7}}`)
+	l := loader.New(merge.WithOverwrite()).Add(base, override)
+
+	var loaded config
+	if err := l.Load(&loaded); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var watched atomic.Pointer[config]
+	if err := merge.Watch(ctx, l.Layers(), &watched, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := config{Name: "api", Hosts: []string{"a", "b"}, Pool: pool{Size: 7, Timeout: 30 * time.Second}, Port: 8080}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", loaded, expected)
+	}
+	if !reflect.DeepEqual(*watched.Load(), loaded) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", *watched.Load(), loaded)
+	}
+}
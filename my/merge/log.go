@@ -0,0 +1,42 @@
+package merge
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+)
+
+// WithLogger makes merge log, at debug level, every decision it takes on a value: its
+// path and kind, the action taken and what it was decided on, whether dst and src are
+// empty and the options that weigh on it.
+func WithLogger(logger *slog.Logger) Option {
+	return func(config *Options) {
+		config.logger = logger
+	}
+}
+
+// trace logs the action taken at path, if debug logging is enabled.
+func (config *Options) trace(path fieldPath, action string, dst, src reflect.Value, attrs ...slog.Attr) {
+	if config.logger == nil {
+		return
+	}
+	ctx := config.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !config.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs = append([]slog.Attr{
+		slog.String("path", path.String()),
+		slog.String("kind", hookKind(src).String()),
+		slog.String("action", action),
+		slog.Bool("dstEmpty", isEmptyValue(dst)),
+		slog.Bool("srcEmpty", isEmptyValue(src)),
+		slog.Bool("overwrite", config.overwrite),
+		slog.Bool("overwriteWithEmptyValue", config.overwriteWithEmptyValue),
+		slog.Bool("overwriteSliceWithEmptyValue", config.overwriteSliceWithEmptyValue),
+		slog.Bool("appendSlice", config.appendSlice),
+	}, attrs...)
+	config.logger.LogAttrs(ctx, slog.LevelDebug, "merge", attrs...)
+}
@@ -3,14 +3,24 @@ package merge
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 	"unicode"
 )
 
 // Traverses recursively both values, assigning src's fields values to dst.
 // The map argument tracks comparisons that have already been seen, which allows
 // short circuiting on recursive types.
-func deepMap(dst, src reflect.Value, visited map[uintptr]*visit, depth int, config *Options) (err error) {
+func deepMap(dst, src reflect.Value, visited map[uintptr]*visit, depth int, path fieldPath, config *Options) (err error) {
 	overwrite := config.overwrite
+	if err = config.checkContext(path); err != nil {
+		return
+	}
+	if err = config.countElement(path); err != nil {
+		return
+	}
+	config.stats.visit()
 	if dst.CanAddr() {
 		addr := dst.UnsafeAddr()
 		h := 17 * addr
@@ -24,20 +34,30 @@ func deepMap(dst, src reflect.Value, visited map[uintptr]*visit, depth int, conf
 		// Remember, remember...
 		visited[h] = &visit{typ, seen, addr}
 	}
-	zeroValue := reflect.Value{}
 	switch dst.Kind() {
 	case reflect.Map:
 		dstMap := dst.Interface().(map[string]interface{})
-		for i, n := 0, src.NumField(); i < n; i++ {
-			srcType := src.Type()
-			field := srcType.Field(i)
-			if !isExported(field) {
+		plan := planOf(src.Type())
+		for i := range plan.fields {
+			field := &plan.fields[i]
+			if !isExported(field.StructField) {
 				continue
 			}
-			fieldName := field.Name
-			fieldName = changeInitialCase(fieldName, unicode.ToLower)
+			fieldName := field.segment
+			fieldPath := config.childPath(path, fieldName)
+			if config.filter(fieldPath) != filterAllow {
+				continue
+			}
+			if err = config.checkImmutable(reflect.ValueOf(dstMap[fieldName]), src.Field(i), fieldPath, field.tag); err != nil {
+				return
+			}
 			if v, ok := dstMap[fieldName]; !ok || (isEmptyValue(reflect.ValueOf(v)) || overwrite) {
-				dstMap[fieldName] = src.Field(i).Interface()
+				if err = config.write(fieldPath, ActionSet, reflect.ValueOf(v), src.Field(i), func(v reflect.Value) reflect.Value {
+					dstMap[fieldName] = v.Interface()
+					return v
+				}); err != nil {
+					return
+				}
 			}
 		}
 	case reflect.Ptr:
@@ -49,15 +69,27 @@ func deepMap(dst, src reflect.Value, visited map[uintptr]*visit, depth int, conf
 		fallthrough
 	case reflect.Struct:
 		srcMap := src.Interface().(map[string]interface{})
+		keys := make([]string, 0, len(srcMap))
 		for key := range srcMap {
+			keys = append(keys, key)
+		}
+		if config.deterministicOrder {
+			sort.Strings(keys)
+		}
+		for _, key := range keys {
+			keyPath := config.childPath(path, key)
 			config.overwriteWithEmptyValue = true
+			if config.filter(keyPath) == filterSkip {
+				continue
+			}
 			srcValue := srcMap[key]
-			fieldName := changeInitialCase(key, unicode.ToUpper)
-			dstElement := dst.FieldByName(fieldName)
-			if dstElement == zeroValue {
+			field, tag, ok := planOf(dst.Type()).fieldByKey(dst.Type(), key, config.lenientKeys)
+			if !ok {
 				// We discard it because the field doesn't exist.
 				continue
 			}
+			fieldName := field.Name
+			dstElement := dst.FieldByIndex(field.Index)
 			srcElement := reflect.ValueOf(srcValue)
 			dstKind := dstElement.Kind()
 			srcKind := srcElement.Kind()
@@ -76,16 +108,36 @@ func deepMap(dst, src reflect.Value, visited map[uintptr]*visit, depth int, conf
 			if !srcElement.IsValid() {
 				continue
 			}
+			// With WithCoercion, values that do not fit the field as they are get coerced
+			// to its type, e.g. "8080" into an int or []interface{} into a []string.
+			if config.coerce && srcElement.Type() != dstElement.Type() && dstKind != reflect.Interface &&
+				(srcKind != reflect.Map || (dstKind != reflect.Struct && dstKind != reflect.Ptr)) {
+				if coerced, cerr := coerceValue(srcElement, dstElement.Type(), config.lenientKeys); cerr == nil {
+					srcElement, srcKind = coerced, coerced.Kind()
+				}
+			}
+			if config.checksField(tag) {
+				if err = config.checkImmutable(dstElement, srcElement, keyPath, tag); err != nil {
+					return
+				}
+				if dstElement.CanSet() && config.filter(keyPath) == filterAllow {
+					if handled, cerr := config.mergeCombined(keyPath, dstElement, srcElement, tag, setter(dstElement)); cerr != nil {
+						return cerr
+					} else if handled {
+						continue
+					}
+				}
+			}
 			if srcKind == dstKind {
-				if err = deepMerge(dstElement, srcElement, visited, depth+1, config); err != nil {
+				if err = deepMerge(dstElement, srcElement, visited, depth+1, keyPath, config); err != nil {
 					return
 				}
 			} else if dstKind == reflect.Interface && dstElement.Kind() == reflect.Interface {
-				if err = deepMerge(dstElement, srcElement, visited, depth+1, config); err != nil {
+				if err = deepMerge(dstElement, srcElement, visited, depth+1, keyPath, config); err != nil {
 					return
 				}
 			} else if srcKind == reflect.Map {
-				if err = deepMap(dstElement, srcElement, visited, depth+1, config); err != nil {
+				if err = deepMap(dstElement, srcElement, visited, depth+1, keyPath, config); err != nil {
 					return
 				}
 			} else {
@@ -96,6 +148,16 @@ func deepMap(dst, src reflect.Value, visited map[uintptr]*visit, depth int, conf
 	return
 }
 
+// normalizeKey lower cases key and drops its underscores and dashes.
+func normalizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, key)
+}
+
 // Map sets fields' values in dst from src.
 // src can be a map with string keys or a struct. dst must be the opposite:
 // if src is a map, dst must be a valid pointer to struct. If src is a struct,
@@ -124,14 +186,22 @@ func _map(dst, src interface{}, opts ...Option) error {
 	for _, opt := range opts {
 		opt(config)
 	}
+	if config.stats != nil {
+		defer config.stats.done(time.Now())
+	}
+	src = resolveSource(dst, src)
 
 	if vDst, vSrc, err = resolveValues(dst, src); err != nil {
 		return err
 	}
+	config.skipPaths = !config.usesPaths(vDst.Type()) && !config.usesPaths(vSrc.Type())
 	// To be friction-less, we redirect equal-type arguments
 	// to deepMerge. Only because arguments can be anything.
 	if vSrc.Kind() == vDst.Kind() {
-		return deepMerge(vDst, vSrc, make(map[uintptr]*visit), 0, config)
+		if err = deepMerge(vDst, vSrc, make(map[uintptr]*visit), 0, nil, config); err != nil {
+			return err
+		}
+		return config.afterMerge(vDst)
 	}
 	switch vSrc.Kind() {
 	case reflect.Struct:
@@ -145,5 +215,8 @@ func _map(dst, src interface{}, opts ...Option) error {
 	default:
 		return ErrNotSupported
 	}
-	return deepMap(vDst, vSrc, make(map[uintptr]*visit), 0, config)
+	if err = deepMap(vDst, vSrc, make(map[uintptr]*visit), 0, nil, config); err != nil {
+		return err
+	}
+	return config.afterMerge(vDst)
 }
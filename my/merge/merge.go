@@ -1,14 +1,17 @@
 package merge
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
+	"time"
 )
 
 // Traverses recursively both values, assigning src's fields values to dst.
 // The map argument tracks comparisons that have already been seen, which allows
 // short circuiting on recursive types.
-func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, config *Options) (err error) {
+func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, path fieldPath, config *Options) (err error) {
 	transformers := config.transformers
 
 	overwrite := config.overwrite
@@ -20,6 +23,46 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 	if !src.IsValid() {
 		return
 	}
+	if err = config.checkContext(path); err != nil {
+		return
+	}
+	if err = config.countElement(path); err != nil {
+		return
+	}
+	config.stats.visit()
+	// Only allowed values may be replaced as a whole; below a filtered
+	// path merge walks into the value and lets its children decide.
+	mode := config.filter(path)
+	if mode == filterSkip {
+		config.trace(path, "skip", dst, src, slog.String("reason", "filtered out"))
+		return
+	}
+	assignable := mode == filterAllow
+
+	if len(config.hooks) > 0 {
+		replacement, replaced, skip, herr := config.hookBefore(ActionMerge, path, dst, src)
+		if skip || herr != nil {
+			return herr
+		}
+		defer func() {
+			if err == nil {
+				err = config.hookAfter(ActionMerge, path, dst, src)
+			}
+		}()
+		if replaced {
+			if !dst.CanSet() {
+				return &replacedError{&PathError{path.String(), errors.New("hook replaced a value that cannot be set")}, path, dst, src, replacement}
+			}
+			return config.set(path, dst, replacement)
+		}
+	}
+
+	if assignable && dst.CanSet() && config.combines() {
+		if handled, cerr := config.mergeCombined(path, dst, src, nil, setter(dst)); handled || cerr != nil {
+			return cerr
+		}
+	}
+
 	if dst.CanAddr() {
 		addr := dst.UnsafeAddr()
 		h := 17 * addr
@@ -33,8 +76,10 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 		visited[h] = &visit{typ, seen, addr}
 	}
 
-	if transformers != nil && !isReflectNil(dst) && dst.IsValid() {
+	if transformers != nil && assignable && !isReflectNil(dst) && dst.IsValid() {
 		if fn := transformers.Transformer(dst.Type()); fn != nil {
+			config.trace(path, "transform", dst, src)
+			config.stats.transform()
 			err = fn(dst, src)
 			return
 		}
@@ -42,15 +87,34 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 
 	switch dst.Kind() {
 	case reflect.Struct:
-		if hasMergeableFields(dst) {
-			for i, n := 0, dst.NumField(); i < n; i++ {
-				if err = deepMerge(dst.Field(i), src.Field(i), visited, depth+1, config); err != nil {
+		if plan := planOf(dst.Type()); plan.mergeable {
+			for i := range plan.fields {
+				field := &plan.fields[i]
+				fieldPath := config.fieldPath(path, field)
+				dstField, srcField := dst.Field(i), src.Field(i)
+				if config.checksField(field.tag) {
+					if err = config.checkImmutable(dstField, srcField, fieldPath, field.tag); err != nil {
+						return
+					}
+					if dstField.CanSet() && config.filter(fieldPath) == filterAllow {
+						if handled, cerr := config.mergeCombined(fieldPath, dstField, srcField, field.tag, setter(dstField)); cerr != nil {
+							return cerr
+						} else if handled {
+							continue
+						}
+					}
+				}
+				if err = deepMerge(dstField, srcField, visited, depth+1, fieldPath, config); err != nil {
 					return
 				}
 			}
 		} else {
-			if dst.CanSet() && (isReflectNil(dst) || overwrite) && (!isEmptyValue(src) || overwriteWithEmptyValue) {
-				dst.Set(src)
+			if assignable && dst.CanSet() && (isReflectNil(dst) || overwrite) && (!isEmptyValue(src) || overwriteWithEmptyValue) {
+				if err = config.set(path, dst, src); err != nil {
+					return
+				}
+			} else {
+				config.trace(path, "keep", dst, src)
 			}
 		}
 	case reflect.Map:
@@ -64,23 +128,61 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 		}
 
 		if src.Kind() != reflect.Map {
-			if overwrite {
-				dst.Set(src)
+			if overwrite && assignable {
+				err = config.set(path, dst, src)
+			} else {
+				config.trace(path, "keep", dst, src)
 			}
 			return
 		}
 
-		for _, key := range src.MapKeys() {
+		parallelKeys := config.parallelMapKeys(dst, src)
+		var parallel map[interface{}]bool
+		if len(parallelKeys) > 0 {
+			parallel = make(map[interface{}]bool, len(parallelKeys))
+			for _, key := range parallelKeys {
+				parallel[key.Interface()] = true
+			}
+		}
+		for _, key := range config.mapKeys(src) {
+			if parallel != nil && parallel[key.Interface()] {
+				continue
+			}
 			srcElement := src.MapIndex(key)
 			if !srcElement.IsValid() {
 				continue
 			}
+			keyPath := config.keyPath(path, key)
+			keyMode := config.filter(keyPath)
+			if keyMode == filterSkip {
+				config.trace(keyPath, "skip", dst.MapIndex(key), srcElement, slog.String("reason", "filtered out"))
+				continue
+			}
 			dstElement := dst.MapIndex(key)
+			if err = config.checkImmutable(dstElement, srcElement, keyPath, nil); err != nil {
+				return
+			}
+			if !dstElement.IsValid() {
+				if err = config.checkMapKeys(path, dst.Len()+1); err != nil {
+					return
+				}
+			}
+			if keyMode == filterAllow && config.combines() {
+				if handled, cerr := config.mergeCombined(keyPath, dstElement, srcElement, nil, config.keySetter(dst, key)); cerr != nil {
+					return cerr
+				} else if handled {
+					continue
+				}
+			}
 			switch srcElement.Kind() {
 			case reflect.Chan, reflect.Func, reflect.Map, reflect.Interface, reflect.Slice:
 				if srcElement.IsNil() {
-					if overwriteWithEmptyValue || overwriteSliceWithEmptyValue {
-						dst.SetMapIndex(key, srcElement)
+					if keyMode == filterAllow && (overwriteWithEmptyValue || overwriteSliceWithEmptyValue) {
+						if err = config.write(keyPath, ActionSet, dstElement, srcElement, config.keySetter(dst, key)); err != nil {
+							return
+						}
+					} else {
+						config.trace(keyPath, "keep", dstElement, srcElement)
 					}
 					continue
 				}
@@ -103,8 +205,25 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 							dstMapElm = reflect.ValueOf(dstMapElm.Interface())
 						}
 					}
-					if err = deepMerge(dstMapElm, srcMapElm, visited, depth+1, config); err != nil {
-						return
+					// A filtered subtree missing from dst is built up from an empty map,
+					// so that only its allowed children are copied over.
+					created := false
+					if keyMode == filterDescend && (!dstMapElm.IsValid() || isReflectNil(dstMapElm)) && srcMapElm.Kind() == reflect.Map {
+						dstMapElm = reflect.MakeMap(srcMapElm.Type())
+						dst.SetMapIndex(key, dstMapElm)
+						dstElement, created = dst.MapIndex(key), true
+						config.stats.keyCreated()
+					}
+					if err = deepMerge(dstMapElm, srcMapElm, visited, depth+1, keyPath, config); err != nil {
+						if err = config.replaceMapElement(dst, key, err); err != nil {
+							return
+						}
+						continue
+					}
+					if created && dstMapElm.Len() == 0 {
+						dst.SetMapIndex(key, reflect.Value{})
+						config.stats.keyDeleted()
+						continue
 					}
 				case reflect.Slice:
 					srcSlice := reflect.ValueOf(srcElement.Interface())
@@ -116,13 +235,38 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 						dstSlice = reflect.ValueOf(dstElement.Interface())
 					}
 
+					if keyMode == filterDescend {
+						config.trace(keyPath, "descend", dstSlice, srcSlice)
+						if err = deepMergeElements(dstSlice, srcSlice, visited, depth, keyPath, config); err != nil {
+							return
+						}
+						continue
+					}
+					if merged, ok, kerr := config.mergeKeyedSlice(dstSlice, srcSlice, visited, depth, keyPath); kerr != nil {
+						return kerr
+					} else if ok {
+						if err = config.write(keyPath, ActionMerge, dstElement, merged, config.keySetter(dst, key)); err != nil {
+							return
+						}
+						continue
+					}
 					if (!isEmptyValue(srcSlice) || overwriteWithEmptyValue || overwriteSliceWithEmptyValue) && (overwrite || isEmptyValue(dstSlice)) && !appendSlice {
-						dstSlice = srcSlice
+						if err = config.write(keyPath, ActionSet, dstElement, srcSlice, func(v reflect.Value) reflect.Value {
+							dstSlice = v
+							return v
+						}); err != nil {
+							return
+						}
 					} else if appendSlice {
 						if srcSlice.Type() != dstSlice.Type() {
 							return fmt.Errorf("cannot append two slices with different type (%s, %s)", srcSlice.Type(), dstSlice.Type())
 						}
-						dstSlice = reflect.AppendSlice(dstSlice, srcSlice)
+						if err = config.write(keyPath, ActionAppend, dstSlice, srcSlice, func(v reflect.Value) reflect.Value {
+							dstSlice = reflect.AppendSlice(dstSlice, v)
+							return dstSlice
+						}); err != nil {
+							return
+						}
 					}
 					dst.SetMapIndex(key, dstSlice)
 				}
@@ -131,46 +275,72 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 				continue
 			}
 
-			if srcElement.IsValid() && ((srcElement.Kind() != reflect.Ptr && overwrite) || !dstElement.IsValid() || isEmptyValue(dstElement)) {
-				if dst.IsNil() {
-					dst.Set(reflect.MakeMap(dst.Type()))
+			if keyMode == filterAllow && srcElement.IsValid() && ((srcElement.Kind() != reflect.Ptr && overwrite) || !dstElement.IsValid() || isEmptyValue(dstElement)) {
+				if err = config.write(keyPath, ActionSet, dstElement, srcElement, config.keySetter(dst, key)); err != nil {
+					return
 				}
-				dst.SetMapIndex(key, srcElement)
+			} else {
+				config.trace(keyPath, "keep", dstElement, srcElement)
 			}
 		}
+		if len(parallelKeys) > 0 {
+			err = config.mergeMapParallel(dst, src, parallelKeys, depth, path)
+		}
 	case reflect.Slice:
 		if !dst.CanSet() {
 			break
 		}
+		if !assignable {
+			config.trace(path, "descend", dst, src)
+			err = deepMergeElements(dst, src, visited, depth, path, config)
+			break
+		}
+		if merged, ok, kerr := config.mergeKeyedSlice(dst, src, visited, depth, path); kerr != nil {
+			return kerr
+		} else if ok {
+			dst.Set(merged)
+			break
+		}
 		if (!isEmptyValue(src) || overwriteWithEmptyValue || overwriteSliceWithEmptyValue) && (overwrite || isEmptyValue(dst)) && !appendSlice {
-			dst.Set(src)
+			if err = config.set(path, dst, src); err != nil {
+				return
+			}
 		} else if appendSlice {
 			if src.Type() != dst.Type() {
 				return fmt.Errorf("cannot append two slice with different type (%s, %s)", src.Type(), dst.Type())
 			}
-			dst.Set(reflect.AppendSlice(dst, src))
+			err = config.write(path, ActionAppend, dst, src, func(v reflect.Value) reflect.Value {
+				dst.Set(reflect.AppendSlice(dst, v))
+				return dst
+			})
+		} else {
+			config.trace(path, "keep", dst, src)
 		}
 	case reflect.Ptr:
 		fallthrough
 	case reflect.Interface:
 		if isReflectNil(src) {
-			if overwriteWithEmptyValue && dst.CanSet() && src.Type().AssignableTo(dst.Type()) {
-				dst.Set(src)
+			if assignable && overwriteWithEmptyValue && dst.CanSet() && src.Type().AssignableTo(dst.Type()) {
+				err = config.set(path, dst, src)
+			} else {
+				config.trace(path, "keep", dst, src)
 			}
 			break
 		}
 
 		if src.Kind() != reflect.Interface {
-			if dst.IsNil() || (src.Kind() != reflect.Ptr && overwrite) {
-				if dst.CanSet() && (overwrite || isEmptyValue(dst)) {
-					dst.Set(src)
+			if dst.IsNil() || (src.Kind() != reflect.Ptr && overwrite && assignable) {
+				if assignable && dst.CanSet() && (overwrite || isEmptyValue(dst)) {
+					if err = config.set(path, dst, src); err != nil {
+						return
+					}
 				}
 			} else if src.Kind() == reflect.Ptr {
-				if err = deepMerge(dst.Elem(), src.Elem(), visited, depth+1, config); err != nil {
+				if err = deepMerge(dst.Elem(), src.Elem(), visited, depth+1, path, config); err != nil {
 					return
 				}
 			} else if dst.Elem().Type() == src.Type() {
-				if err = deepMerge(dst.Elem(), src, visited, depth+1, config); err != nil {
+				if err = deepMerge(dst.Elem(), src, visited, depth+1, path, config); err != nil {
 					return
 				}
 			} else {
@@ -179,33 +349,67 @@ func deepMerge(dst, src reflect.Value, visited map[uintptr]*visit, depth int, co
 			break
 		}
 
-		if dst.IsNil() || overwrite {
-			if dst.CanSet() && (overwrite || isEmptyValue(dst)) {
-				dst.Set(src)
+		if dst.IsNil() || (overwrite && assignable) {
+			if assignable && dst.CanSet() && (overwrite || isEmptyValue(dst)) {
+				if err = config.set(path, dst, src); err != nil {
+					return
+				}
 			}
 			break
 		}
 
 		if dst.Elem().Kind() == src.Elem().Kind() {
-			if err = deepMerge(dst.Elem(), src.Elem(), visited, depth+1, config); err != nil {
+			if err = deepMerge(dst.Elem(), src.Elem(), visited, depth+1, path, config); err != nil {
 				return
 			}
 			break
 		}
 	default:
-		mustSet := (isEmptyValue(dst) || overwrite) && (!isEmptyValue(src) || overwriteWithEmptyValue)
+		mustSet := assignable && (isEmptyValue(dst) || overwrite) && (!isEmptyValue(src) || overwriteWithEmptyValue)
 		if mustSet {
 			if dst.CanSet() {
-				dst.Set(src)
+				if err = config.set(path, dst, src); err != nil {
+					return
+				}
 			} else {
 				dst = src
 			}
+		} else {
+			config.trace(path, "keep", dst, src)
 		}
 	}
 
 	return
 }
 
+// deepMergeElements merges the elements src and dst have in common one by one,
+// instead of replacing dst as a whole.
+func deepMergeElements(dst, src reflect.Value, visited map[uintptr]*visit, depth int, path fieldPath, config *Options) error {
+	n := dst.Len()
+	if src.Len() < n {
+		n = src.Len()
+	}
+	if config.parallelElements(dst, n) {
+		return config.parallel(n, func(i int, visited map[uintptr]*visit, inner *Options) error {
+			elemPath := inner.indexPath(path, i)
+			if err := inner.checkImmutable(dst.Index(i), src.Index(i), elemPath, nil); err != nil {
+				return err
+			}
+			return deepMerge(dst.Index(i), src.Index(i), visited, depth+1, elemPath, inner)
+		})
+	}
+	for i := 0; i < n; i++ {
+		elemPath := config.indexPath(path, i)
+		if err := config.checkImmutable(dst.Index(i), src.Index(i), elemPath, nil); err != nil {
+			return err
+		}
+		if err := deepMerge(dst.Index(i), src.Index(i), visited, depth+1, elemPath, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func merge(dst, src interface{}, opts ...Option) error {
 	if dst != nil && reflect.ValueOf(dst).Kind() != reflect.Ptr {
 		return ErrNonPointerAgument
@@ -220,6 +424,10 @@ func merge(dst, src interface{}, opts ...Option) error {
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.stats != nil {
+		defer options.stats.done(time.Now())
+	}
+	src = resolveSource(dst, src)
 
 	if vDst, vSrc, err = resolveValues(dst, src); err != nil {
 		return err
@@ -227,7 +435,65 @@ func merge(dst, src interface{}, opts ...Option) error {
 	if vDst.Type() != vSrc.Type() {
 		return ErrDifferentArgumentsTypes
 	}
-	return deepMerge(vDst, vSrc, make(map[uintptr]*visit), 0, options)
+	options.skipPaths = !options.usesPaths(vDst.Type())
+	if err = deepMerge(vDst, vSrc, make(map[uintptr]*visit), 0, nil, options); err != nil {
+		return err
+	}
+	return options.afterMerge(vDst)
+}
+
+// afterMerge runs the passes that need the fully merged dst.
+func (config *Options) afterMerge(dst reflect.Value) error {
+	if config.defaultsFromTags && dst.Kind() == reflect.Struct {
+		if err := applyDefaults(dst, nil, newDefaultsVisit()); err != nil {
+			return err
+		}
+	}
+	if config.interpolation != nil {
+		if err := config.interpolation.run(dst); err != nil {
+			return err
+		}
+	}
+	if config.checkRequiredFields {
+		if err := config.checkRequired(dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// written is called every time deepMerge or deepMap stores a src value in dst.
+func (config *Options) written(path fieldPath) {
+	if config.provenance != nil {
+		config.provenance.record(path.String(), config.origin, config.sourceNode)
+	}
+}
+
+// replacedError is returned by deepMerge when a hook replaced dst, which cannot be set:
+// the map holding dst writes the replacement instead, see replaceMapElement.
+type replacedError struct {
+	err      *PathError
+	path     fieldPath
+	dst, src reflect.Value
+	v        reflect.Value
+}
+
+func (e *replacedError) Error() string { return e.err.Error() }
+
+func (e *replacedError) Unwrap() error { return e.err }
+
+// replaceMapElement writes at key of the map dst the value a hook replaced its element
+// with when err, returned by deepMerge for that element, is a replacedError, then runs
+// the hooks after ActionMerge. It returns any other error as is.
+func (config *Options) replaceMapElement(dst, key reflect.Value, err error) error {
+	r, ok := err.(*replacedError)
+	if !ok {
+		return err
+	}
+	if err = config.write(r.path, ActionSet, r.dst, r.v, config.keySetter(dst, key)); err != nil {
+		return err
+	}
+	return config.hookAfter(ActionMerge, r.path, dst.MapIndex(key), r.src)
 }
 
 // Merge will fill any empty for value type attributes on the dst struct using corresponding
@@ -1,10 +1,21 @@
 package merge_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"math"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -2111,3 +2122,2035 @@ func TestV039Issue146(t *testing.T) {
 		t.Errorf("expected %v, got nil", &s2)
 	}
 }
+
+type provenancePool struct {
+	Size    int
+	Timeout time.Duration
+}
+
+type provenanceDB struct {
+	Host string
+	Pool provenancePool
+}
+
+type provenanceConfig struct {
+	Name string
+	DB   provenanceDB
+}
+
+func TestProvenance(t *testing.T) {
+	var prov merge.Provenance
+	cfg := provenanceConfig{}
+	defaults := provenanceConfig{Name: "app", DB: provenanceDB{Host: "localhost", Pool: provenancePool{Size: 5}}}
+	override := provenanceConfig{DB: provenanceDB{Pool: provenancePool{Size: 20, Timeout: time.Second}}}
+
+	if err := merge.Merge(&cfg, defaults, merge.WithProvenance(&prov, "defaults")); err != nil {
+		t.Fatal(err)
+	}
+	if err := merge.Merge(&cfg, override, merge.WithOverwrite(), merge.WithProvenance(&prov, "override")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"name":            "defaults",
+		"dB.host":         "defaults",
+		"dB.pool.size":    "override",
+		"dB.pool.timeout": "override",
+	}
+	for path, source := range expected {
+		origin, ok := prov.Origin(path)
+		if !ok {
+			t.Errorf("no origin recorded for %s", path)
+			continue
+		}
+		if origin.Source != source {
+			t.Errorf("origin of %s: got %q, want %q", path, origin.Source, source)
+		}
+	}
+	if _, ok := prov.Origin("dB.port"); ok {
+		t.Errorf("dB.port was never written but has an origin")
+	}
+}
+
+func TestProvenanceReplacedSubtree(t *testing.T) {
+	prov := merge.NewProvenance()
+	dst := map[string]interface{}{}
+	base := map[string]interface{}{"db": map[string]interface{}{"host": "a", "port": 1}, "name": "app"}
+	if err := merge.Merge(&dst, base, merge.WithProvenance(prov, "base")); err != nil {
+		t.Fatal(err)
+	}
+	if err := merge.Merge(&dst, map[string]interface{}{"db": "disabled"}, merge.WithOverwrite(), merge.WithProvenance(prov, "override")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]merge.Origin{"db": {Source: "override"}, "name": {Source: "base"}}
+	if origins := prov.Origins(); !reflect.DeepEqual(origins, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", origins, expected)
+	}
+	if origin, ok := prov.Origin("db.host"); !ok || origin.Source != "override" {
+		t.Errorf("expected db.host to come from its replaced ancestor, got %v", origin)
+	}
+}
+
+func TestProvenanceYAMLNode(t *testing.T) {
+	raw := []byte("db:\n  pool:\n    size: 10\nname: app\n")
+	var node yaml.Node
+	if err := yaml.Unmarshal(raw, &node); err != nil {
+		t.Fatal(err)
+	}
+	var src map[string]interface{}
+	if err := node.Decode(&src); err != nil {
+		t.Fatal(err)
+	}
+
+	prov := merge.NewProvenance()
+	dst := map[string]interface{}{"name": "base"}
+	if err := merge.Merge(&dst, src, merge.WithProvenance(prov, "values"), merge.WithSourceNode("values.yaml", &node)); err != nil {
+		t.Fatal(err)
+	}
+
+	origin, ok := prov.Origin("db.pool.size")
+	if !ok {
+		t.Fatalf("no origin recorded for db.pool.size")
+	}
+	if origin.Source != "values" || origin.File != "values.yaml" || origin.Line != 3 {
+		t.Errorf("unexpected origin for db.pool.size: %+v", origin)
+	}
+	if _, ok := prov.Origin("name"); ok {
+		t.Errorf("name was kept from dst but has an origin")
+	}
+
+	var buf bytes.Buffer
+	if err := prov.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "db\tvalues (values.yaml:1:1)\n"; got != want {
+		t.Errorf("Dump: got %q, want %q", got, want)
+	}
+}
+
+func TestProvenanceMap(t *testing.T) {
+	prov := merge.NewProvenance()
+	cfg := provenanceConfig{}
+	src := map[string]interface{}{"name": "app"}
+	if err := merge.Map(&cfg, src, merge.WithProvenance(prov, "env")); err != nil {
+		t.Fatal(err)
+	}
+	if origin, ok := prov.Origin("name"); !ok || origin.Source != "env" {
+		t.Errorf("unexpected origin for name: %+v", origin)
+	}
+}
+
+func TestIncludeExcludeMaps(t *testing.T) {
+	dst := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":   "web",
+			"labels": map[string]interface{}{"app": "web"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 2,
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "image": "web:1"},
+			},
+		},
+	}
+	src := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":   "hijacked",
+			"labels": map[string]interface{}{"app": "tenant", "tier": "gold"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 100,
+			"containers": []interface{}{
+				map[string]interface{}{"name": "evil", "image": "web:2"},
+			},
+		},
+		"status": map[string]interface{}{"phase": "Running"},
+	}
+	expected := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":   "web",
+			"labels": map[string]interface{}{"app": "tenant", "tier": "gold"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 2,
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "image": "web:2"},
+			},
+		},
+	}
+
+	err := merge.Merge(&dst, src, merge.WithOverwrite(),
+		merge.WithInclude("spec.containers.*.image", "metadata.labels.**"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+type filterContainer struct {
+	Name  string
+	Image string
+}
+
+type filterSpec struct {
+	Replicas   int
+	Containers []filterContainer
+}
+
+type filterDeployment struct {
+	Name string
+	Spec filterSpec
+}
+
+func TestIncludeExcludeStructs(t *testing.T) {
+	dst := filterDeployment{
+		Name: "web",
+		Spec: filterSpec{Replicas: 2, Containers: []filterContainer{{Name: "web", Image: "web:1"}}},
+	}
+	src := filterDeployment{
+		Name: "tenant",
+		Spec: filterSpec{Replicas: 3, Containers: []filterContainer{{Name: "evil", Image: "web:2"}}},
+	}
+	expected := filterDeployment{
+		Name: "web",
+		Spec: filterSpec{Replicas: 3, Containers: []filterContainer{{Name: "web", Image: "web:2"}}},
+	}
+
+	err := merge.Merge(&dst, src, merge.WithOverwrite(),
+		merge.WithInclude("spec.**"), merge.WithExclude("spec.containers.*.name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func TestExcludeMissingSubtree(t *testing.T) {
+	dst := map[string]interface{}{}
+	src := map[string]interface{}{
+		"db": map[string]interface{}{"host": "db.internal", "password": "secret"},
+	}
+	if err := merge.Merge(&dst, src, merge.WithExclude("db.password")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"db": map[string]interface{}{"host": "db.internal"},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+type immutableCluster struct {
+	Name     string `merge:"immutable"`
+	Region   string `merge:"writeonce"`
+	Replicas int
+}
+
+func TestImmutableField(t *testing.T) {
+	dst := immutableCluster{Name: "prod", Region: "eu-west-1", Replicas: 3}
+	if err := merge.Merge(&dst, immutableCluster{Name: "prod", Replicas: 5}, merge.WithOverwrite()); err != nil {
+		t.Fatalf("repeating an immutable value must not fail: %v", err)
+	}
+	if dst.Replicas != 5 {
+		t.Errorf("expected replicas to be overwritten, got %d", dst.Replicas)
+	}
+
+	err := merge.Merge(&dst, immutableCluster{Name: "staging"}, merge.WithOverwrite())
+	if !errors.Is(err, merge.ErrImmutableField) {
+		t.Fatalf("expected ErrImmutableField, got %v", err)
+	}
+	var pathErr *merge.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "name" {
+		t.Errorf("expected a *PathError for name, got %#v", err)
+	}
+	if dst.Name != "prod" {
+		t.Errorf("immutable field was changed to %q", dst.Name)
+	}
+
+	empty := immutableCluster{}
+	if err := merge.Merge(&empty, immutableCluster{Name: "prod"}); err != nil || empty.Name != "prod" {
+		t.Errorf("filling an empty immutable field must not fail, got %q (%v)", empty.Name, err)
+	}
+}
+
+func TestImmutableFieldWithoutOverwrite(t *testing.T) {
+	dst := immutableCluster{Name: "prod", Region: "eu-west-1"}
+	if err := merge.Merge(&dst, immutableCluster{Name: "staging", Region: "us-east-1", Replicas: 2}); err != nil {
+		t.Fatalf("a merge that keeps dst must not fail: %v", err)
+	}
+	expected := immutableCluster{Name: "prod", Region: "eu-west-1", Replicas: 2}
+	if dst != expected {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+
+	labels := struct {
+		Tags []string `merge:"immutable"`
+	}{[]string{"a"}}
+	err := merge.Merge(&labels, struct {
+		Tags []string `merge:"immutable"`
+	}{[]string{"b"}}, merge.WithAppendSlice())
+	if !errors.Is(err, merge.ErrImmutableField) {
+		t.Errorf("expected ErrImmutableField when appending, got %v", err)
+	}
+}
+
+func TestImmutablePartialStruct(t *testing.T) {
+	type inner struct {
+		A, B string
+	}
+	type outer struct {
+		In inner `merge:"immutable"`
+	}
+	dst := outer{In: inner{A: "x"}}
+	err := merge.Merge(&dst, outer{In: inner{B: "y"}})
+	var pathErr *merge.PathError
+	if !errors.Is(err, merge.ErrImmutableField) || !errors.As(err, &pathErr) || pathErr.Path != "in.b" {
+		t.Errorf("expected ErrImmutableField at in.b, got %v", err)
+	}
+	if expected := (outer{In: inner{A: "x"}}); dst != expected {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+
+	// Without WithOverwriteWithEmptyValue, the empty B of src is not written.
+	dst = outer{In: inner{A: "x", B: "y"}}
+	if err := merge.Merge(&dst, outer{In: inner{A: "x"}}, merge.WithOverwrite()); err != nil {
+		t.Errorf("a merge that keeps dst must not fail: %v", err)
+	}
+	if err := merge.Merge(&dst, outer{In: inner{A: "z"}}); err != nil {
+		t.Errorf("a merge that keeps dst must not fail: %v", err)
+	}
+	err = merge.Merge(&dst, outer{In: inner{A: "z"}}, merge.WithOverwrite())
+	if !errors.Is(err, merge.ErrImmutableField) || !errors.As(err, &pathErr) || pathErr.Path != "in.a" {
+		t.Errorf("expected ErrImmutableField at in.a, got %v", err)
+	}
+	if expected := (outer{In: inner{A: "x", B: "y"}}); dst != expected {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func TestWriteOnceField(t *testing.T) {
+	dst := immutableCluster{}
+	if err := merge.Merge(&dst, immutableCluster{Region: "eu-west-1"}); err != nil {
+		t.Fatalf("filling an empty write-once field must not fail: %v", err)
+	}
+	if dst.Region != "eu-west-1" {
+		t.Errorf("expected region to be filled, got %q", dst.Region)
+	}
+	if err := merge.Merge(&dst, immutableCluster{Region: "us-east-1"}, merge.WithOverwrite()); !errors.Is(err, merge.ErrWriteOnceField) {
+		t.Errorf("expected ErrWriteOnceField, got %v", err)
+	}
+	if err := merge.Merge(&dst, immutableCluster{}, merge.WithOverwriteWithEmptyValue()); !errors.Is(err, merge.ErrWriteOnceField) {
+		t.Errorf("expected ErrWriteOnceField when clearing, got %v", err)
+	}
+}
+
+func TestImmutablePaths(t *testing.T) {
+	dst := map[string]interface{}{
+		"cluster": map[string]interface{}{"name": "prod", "size": 3},
+	}
+	src := map[string]interface{}{
+		"cluster": map[string]interface{}{"name": "staging", "size": 5},
+	}
+	err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithImmutablePaths("cluster.name"))
+	var pathErr *merge.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "cluster.name" || !errors.Is(err, merge.ErrImmutableField) {
+		t.Errorf("expected an immutable error on cluster.name, got %v", err)
+	}
+	if name := dst["cluster"].(map[string]interface{})["name"]; name != "prod" {
+		t.Errorf("immutable path was changed to %v", name)
+	}
+}
+
+type defaultsPool struct {
+	Size    int           `default:"10"`
+	Timeout time.Duration `default:"30s"`
+}
+
+type defaultsTLS struct {
+	Enabled bool `default:"true"`
+}
+
+type defaultsConfig struct {
+	Port  int      `default:"8080"`
+	Hosts []string `default:"[a,b]"`
+	Name  string
+	Pool  defaultsPool
+	TLS   *defaultsTLS
+	Extra *simpleTest
+}
+
+func TestApplyDefaults(t *testing.T) {
+	cfg := defaultsConfig{Port: 9090}
+	if err := merge.ApplyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	expected := defaultsConfig{
+		Port:  9090,
+		Hosts: []string{"a", "b"},
+		Pool:  defaultsPool{Size: 10, Timeout: 30 * time.Second},
+		TLS:   &defaultsTLS{Enabled: true},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", cfg, expected)
+	}
+}
+
+func TestApplyDefaultsInvalid(t *testing.T) {
+	var cfg struct {
+		Port int `default:"http"`
+	}
+	err := merge.ApplyDefaults(&cfg)
+	var pathErr *merge.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "port" || !errors.Is(err, merge.ErrCannotCoerce) {
+		t.Errorf("expected a coercion error on port, got %v", err)
+	}
+}
+
+type defaultsNode struct {
+	V    int `default:"1"`
+	Next *defaultsNode
+}
+
+func TestApplyDefaultsRecursiveType(t *testing.T) {
+	node := defaultsNode{Next: &defaultsNode{V: 2}}
+	node.Next.Next = &node
+	if err := merge.ApplyDefaults(&node); err != nil {
+		t.Fatal(err)
+	}
+	if node.V != 1 || node.Next.V != 2 || node.Next.Next != &node {
+		t.Errorf("unexpected node %#v", node)
+	}
+	var empty defaultsNode
+	if err := merge.ApplyDefaults(&empty); err != nil {
+		t.Fatal(err)
+	}
+	if empty.V != 1 || empty.Next != nil {
+		t.Errorf("nil pointers to a type being filled should be left nil, got %#v", empty)
+	}
+}
+
+func TestMergeWithDefaultsFromTags(t *testing.T) {
+	dst := defaultsConfig{}
+	src := defaultsConfig{Pool: defaultsPool{Size: 50}}
+	if err := merge.Merge(&dst, src, merge.WithDefaultsFromTags()); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Pool.Size != 50 || dst.Pool.Timeout != 30*time.Second || dst.Port != 8080 {
+		t.Errorf("defaults not applied after merge: %#v", dst)
+	}
+}
+
+func TestMapCoercesStrings(t *testing.T) {
+	dst := defaultsConfig{}
+	src := map[string]interface{}{
+		"port":  "8080",
+		"hosts": "a, b",
+		"pool":  map[string]interface{}{"timeout": "1m"},
+	}
+	if err := merge.Map(&defaultsConfig{}, src); err == nil {
+		t.Error("strings should not be coerced without WithCoercion")
+	}
+	if err := merge.Map(&dst, src, merge.WithCoercion()); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Port != 8080 || !reflect.DeepEqual(dst.Hosts, []string{"a", "b"}) || dst.Pool.Timeout != time.Minute {
+		t.Errorf("values not coerced: %#v", dst)
+	}
+}
+
+type requiredDB struct {
+	Address string `merge:"required"`
+	Pool    int
+}
+
+type requiredConfig struct {
+	Name     string `merge:"required"`
+	Database requiredDB
+	Port     int
+}
+
+func TestRequiredFields(t *testing.T) {
+	dst := requiredConfig{}
+	err := merge.Merge(&dst, requiredConfig{Port: 80}, merge.WithRequired("port", "database.pool"))
+	var missingErr *merge.MissingFieldsError
+	if !errors.As(err, &missingErr) || !errors.Is(err, merge.ErrRequiredField) {
+		t.Fatalf("expected a *MissingFieldsError, got %v", err)
+	}
+	if expected := []string{"name", "database.address", "database.pool"}; !reflect.DeepEqual(missingErr.Paths, expected) {
+		t.Errorf("missing paths: got %v, want %v", missingErr.Paths, expected)
+	}
+
+	src := requiredConfig{Name: "app", Database: requiredDB{Address: "postgres://db", Pool: 4}}
+	if err := merge.Merge(&dst, src, merge.WithRequired("port", "database.pool")); err != nil {
+		t.Errorf("all required fields are set, got %v", err)
+	}
+}
+
+func TestRequiredPathsInMaps(t *testing.T) {
+	dst := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a"},
+			map[string]interface{}{"host": ""},
+		},
+	}
+	src := map[string]interface{}{"name": "app"}
+	err := merge.Merge(&dst, src, merge.WithRequired("name", "db.url", "servers.*.host"))
+	var missingErr *merge.MissingFieldsError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected a *MissingFieldsError, got %v", err)
+	}
+	if expected := []string{"servers.1.host", "db"}; !reflect.DeepEqual(missingErr.Paths, expected) {
+		t.Errorf("missing paths: got %v, want %v", missingErr.Paths, expected)
+	}
+}
+
+func TestCheckRequired(t *testing.T) {
+	if err := merge.CheckRequired(&requiredConfig{Name: "app", Database: requiredDB{Address: "x"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := merge.CheckRequired(requiredConfig{}, "port"); !errors.Is(err, merge.ErrRequiredField) {
+		t.Errorf("expected ErrRequiredField, got %v", err)
+	}
+}
+
+func TestCheckRequiredSharedPointer(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	type config struct {
+		Primary   *requiredDB
+		Secondary *requiredDB
+		Head      *node
+	}
+	shared := &requiredDB{Address: "postgres://db"}
+	head := &node{Name: "a"}
+	head.Next = head
+	err := merge.CheckRequired(&config{Primary: shared, Secondary: shared, Head: head}, "primary.pool", "secondary.pool")
+	var missingErr *merge.MissingFieldsError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected a *MissingFieldsError, got %v", err)
+	}
+	if expected := []string{"primary.pool", "secondary.pool"}; !reflect.DeepEqual(missingErr.Paths, expected) {
+		t.Errorf("missing paths: got %v, want %v", missingErr.Paths, expected)
+	}
+}
+
+func TestCheckRequiredInMapsAndSlices(t *testing.T) {
+	type config struct {
+		DBs  map[string]requiredDB
+		List []requiredDB
+		Any  []interface{}
+	}
+	cfg := config{
+		DBs:  map[string]requiredDB{"a": {}, "b": {Address: "x"}},
+		List: []requiredDB{{Address: "y"}, {}},
+		Any:  []interface{}{requiredDB{}},
+	}
+	err := merge.CheckRequired(&cfg)
+	var missingErr *merge.MissingFieldsError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected a *MissingFieldsError, got %v", err)
+	}
+	if expected := []string{"dBs.a.address", "list.1.address", "any.0.address"}; !reflect.DeepEqual(missingErr.Paths, expected) {
+		t.Errorf("missing paths: got %v, want %v", missingErr.Paths, expected)
+	}
+}
+
+type envServer struct {
+	Host string
+	Port int
+}
+
+type envDB struct {
+	PoolSize int
+	Timeout  time.Duration
+}
+
+type envConfig struct {
+	Name    string
+	Debug   bool
+	DB      envDB
+	Hosts   []string
+	Tags    []string
+	Servers []envServer
+	Labels  map[string]string
+}
+
+func TestFromEnv(t *testing.T) {
+	environ := func() []string {
+		return []string{
+			"APP_NAME=api",
+			"APP_DEBUG=true",
+			"APP_DB__POOL_SIZE=10",
+			"APP_DB__TIMEOUT=5s",
+			"APP_HOSTS_0=a",
+			"APP_HOSTS_1=b",
+			"APP_TAGS=x,y",
+			"APP_SERVERS_0__HOST=db1",
+			"APP_SERVERS_0__PORT=5432",
+			"APP_LABELS__TEAM=core",
+			"OTHER_NAME=ignored",
+		}
+	}
+	cfg := envConfig{Name: "default", DB: envDB{PoolSize: 1}}
+	if err := merge.Map(&cfg, merge.FromEnv("APP", merge.WithEnviron(environ)), merge.WithOverwrite(), merge.WithCoercion(), merge.WithLenientKeys()); err != nil {
+		t.Fatal(err)
+	}
+	expected := envConfig{
+		Name:    "api",
+		Debug:   true,
+		DB:      envDB{PoolSize: 10, Timeout: 5 * time.Second},
+		Hosts:   []string{"a", "b"},
+		Tags:    []string{"x", "y"},
+		Servers: []envServer{{Host: "db1", Port: 5432}},
+		Labels:  map[string]string{"team": "core"},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", cfg, expected)
+	}
+}
+
+func TestFromEnvWithoutLenientKeysAndCoercion(t *testing.T) {
+	environ := func() []string { return []string{"APP_NAME=api", "APP_DB__POOL_SIZE=10"} }
+	var cfg envConfig
+	if err := merge.Map(&cfg, merge.FromEnv("APP", merge.WithEnviron(environ)), merge.WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (envConfig{Name: "api"}); !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", cfg, expected)
+	}
+
+	environ = func() []string { return []string{"APP_DEBUG=true"} }
+	if err := merge.Map(&cfg, merge.FromEnv("APP", merge.WithEnviron(environ)), merge.WithOverwrite(), merge.WithLenientKeys()); err == nil || cfg.Debug {
+		t.Errorf("expected an error mapping a string onto a bool without WithCoercion, got %v and %#v", err, cfg)
+	}
+}
+
+func TestMapStrictKeys(t *testing.T) {
+	src := map[string]interface{}{"pool_size": 10, "timeout": time.Second}
+	var dst envDB
+	if err := merge.Map(&dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (envDB{Timeout: time.Second}); !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+	if err := merge.Map(&dst, src, merge.WithLenientKeys()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (envDB{PoolSize: 10, Timeout: time.Second}); !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func TestFromEnvTree(t *testing.T) {
+	environ := func() []string {
+		return []string{"APP_DB__POOL_SIZE=10", "APP_HOSTS_1=b", "APP_HOSTS_999999999=x", "APP_HOSTS_99999999999999999999=y", "APP_OAUTH2=on"}
+	}
+	expected := map[string]interface{}{
+		"db":     map[string]interface{}{"pool_size": "10"},
+		"hosts":  []interface{}{nil, "b"},
+		"oauth2": "on",
+	}
+	if tree := merge.FromEnv("APP_", merge.WithEnviron(environ)); !reflect.DeepEqual(tree, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", tree, expected)
+	}
+}
+
+type flagDB struct {
+	PoolSize int    `flag:"db-pool-size"`
+	Host     string `flag:"db-host"`
+}
+
+type flagConfig struct {
+	Verbose bool
+	Timeout time.Duration
+	DB      flagDB
+}
+
+func TestFromFlagSet(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("verbose", false, "")
+	fs.Duration("timeout", 0, "")
+	fs.Int("db-pool-size", 0, "")
+	fs.String("db-host", "localhost", "")
+	if err := fs.Parse([]string{"-db-pool-size=20", "-timeout=1m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := flagConfig{Verbose: true, DB: flagDB{PoolSize: 5, Host: "db.internal"}}
+	if err := merge.Map(&cfg, merge.FromFlagSet(fs), merge.WithOverwrite(), merge.WithCoercion()); err != nil {
+		t.Fatal(err)
+	}
+	expected := flagConfig{Verbose: true, Timeout: time.Minute, DB: flagDB{PoolSize: 20, Host: "db.internal"}}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", cfg, expected)
+	}
+}
+
+func TestFromFlagSetDottedNames(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("db.host", "", "")
+	fs.String("name", "", "")
+	if err := fs.Parse([]string{"-db.host=db.internal"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := map[string]interface{}{"name": "app"}
+	if err := merge.Merge(&dst, merge.FromFlagSet(fs)); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"name": "app",
+		"db":   map[string]interface{}{"host": "db.internal"},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+type keyedContainer struct {
+	Name  string
+	Image string
+	Ports []int
+}
+
+func TestMergeKeys(t *testing.T) {
+	dst := struct{ Containers []keyedContainer }{
+		Containers: []keyedContainer{{Name: "app", Image: "app:1"}, {Name: "sidecar", Image: "proxy:1"}},
+	}
+	src := struct{ Containers []keyedContainer }{
+		Containers: []keyedContainer{{Name: "sidecar", Image: "proxy:2", Ports: []int{15001}}, {Name: "init", Image: "busybox"}},
+	}
+	if err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithMergeKeys("name")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []keyedContainer{
+		{Name: "app", Image: "app:1"},
+		{Name: "sidecar", Image: "proxy:2", Ports: []int{15001}},
+		{Name: "init", Image: "busybox"},
+	}
+	if !reflect.DeepEqual(dst.Containers, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst.Containers, expected)
+	}
+}
+
+func TestMergeKeysInMaps(t *testing.T) {
+	dst := map[string]interface{}{
+		"env": []interface{}{
+			map[string]interface{}{"name": "MODE", "value": "dev"},
+		},
+	}
+	src := map[string]interface{}{
+		"env": []interface{}{
+			map[string]interface{}{"name": "MODE", "value": "prod"},
+			map[string]interface{}{"name": "DEBUG", "value": "1"},
+		},
+	}
+	if err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithMergeKeys("name")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"env": []interface{}{
+			map[string]interface{}{"name": "MODE", "value": "prod"},
+			map[string]interface{}{"name": "DEBUG", "value": "1"},
+		},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func TestMergeKeysInMapsWritePath(t *testing.T) {
+	base := func() map[string]interface{} {
+		return map[string]interface{}{
+			"env": []interface{}{
+				map[string]interface{}{"name": "MODE", "value": "dev"},
+				map[string]interface{}{"name": "LEVEL", "value": "info"},
+			},
+		}
+	}
+	src := map[string]interface{}{
+		"env": []interface{}{map[string]interface{}{"name": "LEVEL", "value": "debug"}},
+	}
+
+	prov := merge.NewProvenance()
+	dst := map[string]interface{}{}
+	if err := merge.Merge(&dst, base(), merge.WithProvenance(prov, "base")); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithMergeKeys("name"), merge.WithProvenance(prov, "override"),
+		merge.WithHook(func(ev merge.HookEvent) error {
+			if ev.Path == "env" {
+				events = append(events, ev.Phase.String()+" "+ev.Action.String())
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"before merge", "after merge"}; !reflect.DeepEqual(events, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", events, expected)
+	}
+	for path, source := range map[string]string{"env.0.value": "base", "env.1.value": "override"} {
+		if origin, ok := prov.Origin(path); !ok || origin.Source != source {
+			t.Errorf("expected %s to come from %s, got %v", path, source, origin)
+		}
+	}
+
+	var stats merge.Stats
+	dst = base()
+	err = merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithMergeKeys("name"), merge.WithStats(&stats), merge.WithProvenance(prov, "hook"),
+		merge.WithHook(func(ev merge.HookEvent) error {
+			if ev.Path == "env" && ev.Phase == merge.HookBefore {
+				ev.Replace([]interface{}{})
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]interface{}{"env": []interface{}{}}; !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+	if origin, ok := prov.Origin("env.0.value"); !ok || origin.Source != "hook" {
+		t.Errorf("expected the replaced env to come from hook, got %v", origin)
+	}
+	if written := stats.Snapshot().Written; written != 3 {
+		t.Errorf("expected the name and value of the element and the replaced env written, got %d writes", written)
+	}
+}
+
+func TestMergeKeysFallBackWithoutKey(t *testing.T) {
+	dst := []map[string]string{{"name": "a"}}
+	src := []map[string]string{{"value": "b"}}
+	if err := merge.Merge(&dst, src, merge.WithAppendSlice(), merge.WithMergeKeys("name")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]string{{"name": "a"}, {"value": "b"}}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func encodeYAML(t *testing.T, node *yaml.Node) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMergeYAMLNodes(t *testing.T) {
+	var dst, src yaml.Node
+	if err := yaml.Unmarshal([]byte(`# Default values.
+replicaCount: 1 # bump for prod
+image:
+  # the registry
+  repository: nginx
+  tag: ""
+env:
+  - name: LOG_LEVEL
+    value: info
+  - name: MODE
+    value: dev
+`), &dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(`defaults: &defaults
+  cpu: 100m
+image:
+  tag: "1.25"
+  repository: ignored
+replicaCount: 3
+env:
+  - name: MODE
+    value: prod
+  - name: EXTRA
+    value: "1"
+resources: *defaults
+`), &src); err != nil {
+		t.Fatal(err)
+	}
+
+	err := merge.MergeYAMLNodes(&dst, &src, merge.WithMergeKeys("name"), merge.WithExclude("defaults"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Default values.
+replicaCount: 1 # bump for prod
+image:
+  # the registry
+  repository: nginx
+  tag: "1.25"
+env:
+  - name: LOG_LEVEL
+    value: info
+  - name: MODE
+    value: dev
+  - name: EXTRA
+    value: "1"
+resources:
+  cpu: 100m
+`
+	if got := encodeYAML(t, &dst); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestMergeYAMLNodesWithOverwrite(t *testing.T) {
+	var dst, src yaml.Node
+	if err := yaml.Unmarshal([]byte("# replicas\nreplicaCount: 1 # prod uses 3\nports: [80]\nname: app\n"), &dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte("ports: [443]\nreplicaCount: 3\n"), &src); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := merge.MergeYAMLNodes(&dst, &src, merge.WithOverwrite(), merge.WithAppendSlice()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# replicas\nreplicaCount: 3 # prod uses 3\nports: [80, 443]\nname: app\n"
+	if got := encodeYAML(t, &dst); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestMergeJSONStream(t *testing.T) {
+	base := `{"name":"app","ports":[80,443],"db":{"host":"localhost","port":5432},"debug":false}`
+	var buf bytes.Buffer
+	err := merge.MergeJSONStream(&buf, strings.NewReader(base),
+		strings.NewReader(`{"db":{"host":"db.internal","user":"app"},"ports":[8080]}`),
+		strings.NewReader(`{"db":{"port":6432},"debug":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"app","ports":[8080],"db":{"host":"db.internal","port":6432,"user":"app"},"debug":true}` + "\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestMergeJSONStreamOptions(t *testing.T) {
+	base := `{"ports":[80],"env":[{"name":"MODE","value":"dev"},{"name":"LOG","value":"info"}],"secret":"s3cr3t"}`
+	var buf bytes.Buffer
+	err := merge.MergeJSONStreamOptions(&buf, strings.NewReader(base),
+		[]io.Reader{strings.NewReader(`{"ports":[443],"env":[{"name":"MODE","value":"prod"},{"name":"DEBUG","value":"1"}],"secret":"leaked"}`)},
+		merge.WithOverwrite(), merge.WithAppendSlice(), merge.WithMergeKeys("name"), merge.WithExclude("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"ports":[80,443],"env":[{"name":"MODE","value":"prod"},{"name":"LOG","value":"info"},{"name":"DEBUG","value":"1"}],"secret":"s3cr3t"}` + "\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestMergeJSONStreamWithoutOverwrite(t *testing.T) {
+	var buf bytes.Buffer
+	err := merge.MergeJSONStreamOptions(&buf, strings.NewReader(`{"name":"app","host":""}`),
+		[]io.Reader{strings.NewReader(`{"name":"other","host":"localhost"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"app","host":"localhost"}` + "\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestMergeJSONStreamInvalidOverlay(t *testing.T) {
+	err := merge.MergeJSONStream(ioutil.Discard, strings.NewReader(`{}`), strings.NewReader(`{}`), strings.NewReader(`{"a":`))
+	if err == nil || !strings.HasPrefix(err.Error(), "overlay 1:") {
+		t.Errorf("expected an error naming overlay 1, got %v", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	doc := map[string]interface{}{
+		"title":  "Goodbye!",
+		"author": map[string]interface{}{"givenName": "John", "familyName": "Doe"},
+		"tags":   []interface{}{"example", "sample"},
+	}
+	patch := map[string]interface{}{
+		"title":  "Hello!",
+		"author": map[string]interface{}{"familyName": nil},
+		"tags":   []interface{}{"example"},
+		"phone":  "+01-123-456-7890",
+	}
+	expected := map[string]interface{}{
+		"title":  "Hello!",
+		"author": map[string]interface{}{"givenName": "John"},
+		"tags":   []interface{}{"example"},
+		"phone":  "+01-123-456-7890",
+	}
+	if got := merge.MergePatch(doc, patch); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", got, expected)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"name": "app",
+		"db":   map[string]interface{}{"host": "localhost", "port": 5432},
+		"tags": []interface{}{"a"},
+	}
+	after := map[string]interface{}{
+		"name": "app",
+		"db":   map[string]interface{}{"host": "db.internal"},
+		"tags": []interface{}{"a", "b"},
+		"new":  true,
+	}
+	expected := []merge.Change{
+		{Op: merge.ChangeReplace, Path: "db.host", Old: "localhost", New: "db.internal"},
+		{Op: merge.ChangeRemove, Path: "db.port", Old: 5432},
+		{Op: merge.ChangeAdd, Path: "new", New: true},
+		{Op: merge.ChangeReplace, Path: "tags", Old: []interface{}{"a"}, New: []interface{}{"a", "b"}},
+	}
+	if got := merge.Diff(before, after); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", got, expected)
+	}
+}
+
+type tenant struct {
+	Name     string
+	Plan     string
+	Quota    int
+	Features []string
+	Limits   map[string]int
+}
+
+func tenants(n int, fill func(i int, t *tenant)) map[string]*tenant {
+	m := make(map[string]*tenant, n)
+	for i := 0; i < n; i++ {
+		t := &tenant{}
+		fill(i, t)
+		m[fmt.Sprintf("tenant-%06d", i)] = t
+	}
+	return m
+}
+
+func tenantPair(n int) (dst, src map[string]*tenant) {
+	dst = tenants(n, func(i int, t *tenant) {
+		t.Name = fmt.Sprint("tenant ", i)
+		if i%2 == 0 {
+			t.Plan = "free"
+		}
+	})
+	src = tenants(n+n/10, func(i int, t *tenant) {
+		t.Plan = "pro"
+		t.Quota = i
+		t.Features = []string{"sso"}
+		t.Limits = map[string]int{"users": i}
+	})
+	return dst, src
+}
+
+func TestWithConcurrency(t *testing.T) {
+	dst, src := tenantPair(5000)
+	expectedDst, expectedSrc := tenantPair(5000)
+	if err := merge.Merge(&expectedDst, expectedSrc, merge.WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+	if err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithConcurrency(8)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, expectedDst) {
+		t.Errorf("concurrent merge differs from the sequential one")
+	}
+}
+
+func TestWithConcurrencySlices(t *testing.T) {
+	build := func() (dst, src []tenant) {
+		dst, src = make([]tenant, 3000), make([]tenant, 3000)
+		for i := range dst {
+			dst[i].Name = fmt.Sprint("tenant ", i)
+			src[i].Quota = i
+		}
+		return dst, src
+	}
+	dst, src := build()
+	expected, _ := build()
+	for i := range expected {
+		expected[i].Quota = i
+	}
+	err := merge.Merge(&struct{ Tenants []tenant }{dst}, struct{ Tenants []tenant }{src}, merge.WithConcurrency(4), merge.WithExclude("tenants.*.name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("concurrent merge differs from the sequential one")
+	}
+}
+
+func TestWithConcurrencySlicesRunConcurrently(t *testing.T) {
+	dst, src := make([]tenant, 2000), make([]tenant, 2000)
+	for i := range src {
+		src[i].Plan = fmt.Sprint("plan ", i)
+	}
+	var inFlight, most atomic.Int64
+	merged := &transformer{map[reflect.Type]func(dst, src reflect.Value) error{
+		reflect.TypeOf(""): func(dst, src reflect.Value) error {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			// Wait for another element to be merged at the same time, until one is.
+			for deadline := time.Now().Add(2 * time.Second); most.Load() < 2 && time.Now().Before(deadline); {
+				runtime.Gosched()
+			}
+			dst.Set(src)
+			return nil
+		},
+	}}
+	err := merge.Merge(&struct{ Tenants []tenant }{dst}, struct{ Tenants []tenant }{src},
+		merge.WithConcurrency(4), merge.WithExclude("tenants.*.name"), merge.WithTransformers(merged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if most.Load() < 2 {
+		t.Errorf("expected the elements to be merged concurrently")
+	}
+	if dst[1999].Plan != "plan 1999" {
+		t.Errorf("expected every element to be merged, got %#v", dst[1999])
+	}
+}
+
+func TestWithConcurrencyErrors(t *testing.T) {
+	for run := 0; run < 10; run++ {
+		dst, src := tenantPair(3000)
+		src["tenant-002999"].Name = "changed"
+		src["tenant-000042"].Name = "changed"
+		src["tenant-001500"].Name = "changed"
+		err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithConcurrency(8), merge.WithImmutablePaths("*.name"))
+		var pathErr *merge.PathError
+		if !errors.As(err, &pathErr) || pathErr.Path != "tenant-000042.name" {
+			t.Fatalf("expected the error of the lowest key, got %v", err)
+		}
+	}
+}
+
+func benchmarkTenants(b *testing.B, opts ...merge.Option) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dst, src := tenantPair(100000)
+		b.StartTimer()
+		if err := merge.Merge(&dst, src, append(opts, merge.WithOverwrite())...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMergeTenants(b *testing.B) {
+	benchmarkTenants(b)
+}
+
+func BenchmarkMergeTenantsConcurrently(b *testing.B) {
+	benchmarkTenants(b, merge.WithConcurrency(runtime.GOMAXPROCS(0)))
+}
+
+type benchTLS struct {
+	Enabled  bool
+	CertFile string
+	KeyFile  string
+}
+
+type benchListener struct {
+	Host         string
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	TLS          *benchTLS
+}
+
+type benchDatabase struct {
+	Driver   string
+	DSN      string
+	MaxOpen  int
+	MaxIdle  int
+	Lifetime time.Duration
+	Replicas []string
+	Options  map[string]string
+}
+
+type benchMeta struct {
+	Name    string
+	Version string
+	Labels  map[string]string
+}
+
+type benchConfig struct {
+	benchMeta
+	HTTP     benchListener
+	GRPC     benchListener
+	Database benchDatabase
+	Cache    *benchDatabase
+	Features map[string]bool
+	Admins   []string
+	Limits   struct {
+		RPS   int
+		Burst int
+	}
+}
+
+func newBenchConfig(i int) benchConfig {
+	var cfg benchConfig
+	cfg.Name = fmt.Sprint("service-", i)
+	cfg.Labels = map[string]string{"team": "core"}
+	cfg.HTTP = benchListener{Port: 8080, ReadTimeout: time.Second, TLS: &benchTLS{}}
+	cfg.GRPC = benchListener{Port: 9090}
+	cfg.Database = benchDatabase{Driver: "postgres", MaxOpen: 10, Options: map[string]string{"sslmode": "disable"}}
+	cfg.Features = map[string]bool{"beta": false}
+	return cfg
+}
+
+func newBenchOverride(i int) benchConfig {
+	var cfg benchConfig
+	cfg.Version = "1.2.3"
+	cfg.Labels = map[string]string{"tenant": fmt.Sprint(i)}
+	cfg.HTTP = benchListener{Host: "0.0.0.0", WriteTimeout: 2 * time.Second, TLS: &benchTLS{Enabled: true, CertFile: "cert.pem"}}
+	cfg.Database = benchDatabase{DSN: "postgres://db", MaxIdle: 5, Replicas: []string{"r1", "r2"}}
+	cfg.Cache = &benchDatabase{Driver: "redis"}
+	cfg.Features = map[string]bool{"beta": true, "sso": true}
+	cfg.Admins = []string{"alice"}
+	cfg.Limits.RPS = 100
+	return cfg
+}
+
+func BenchmarkMergeConfig(b *testing.B) {
+	src := newBenchOverride(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst := newBenchConfig(i)
+		if err := merge.Merge(&dst, src, merge.WithOverwrite()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMapConfig(b *testing.B) {
+	src := map[string]interface{}{
+		"name": "service",
+		"http": map[string]interface{}{"host": "0.0.0.0", "port": 8080, "readTimeout": "5s"},
+		"database": map[string]interface{}{
+			"driver": "postgres", "dsn": "postgres://db", "max_open": 20, "replicas": []interface{}{"r1", "r2"},
+		},
+		"features": map[string]interface{}{"beta": true},
+		"admins":   []interface{}{"alice", "bob"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var dst benchConfig
+		if err := merge.Map(&dst, src, merge.WithOverwrite(), merge.WithCoercion()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMergeTenantSettings(b *testing.B) {
+	src := make(map[string]benchConfig, 100)
+	for i := 0; i < 100; i++ {
+		src[fmt.Sprint("tenant-", i)] = newBenchOverride(i)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dst := make(map[string]*benchConfig, 100)
+		for j := 0; j < 100; j++ {
+			cfg := newBenchConfig(j)
+			dst[fmt.Sprint("tenant-", j)] = &cfg
+		}
+		b.StartTimer()
+		for key, override := range src {
+			if err := merge.Merge(dst[key], override, merge.WithOverwrite()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// expiringContext is done after its Err method was called n times.
+type expiringContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *expiringContext) Err() error {
+	if ctx.n--; ctx.n < 0 {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func TestMergeContext(t *testing.T) {
+	dst := make(map[string]interface{})
+	src := make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		src[fmt.Sprint(i)] = map[string]interface{}{"value": i}
+	}
+
+	ctx := &expiringContext{Context: context.Background(), n: 3}
+	err := merge.MergeContext(ctx, &dst, src)
+	var pathErr *merge.PathError
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &pathErr) || pathErr.Path == "" {
+		t.Fatalf("expected a deadline error with a path, got %v", err)
+	}
+	if len(dst) == 0 || len(dst) == len(src) {
+		t.Errorf("expected dst to be partially merged, got %d of %d entries", len(dst), len(src))
+	}
+
+	dst = make(map[string]interface{})
+	if err = merge.MergeContext(context.Background(), &dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, src) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, src)
+	}
+}
+
+func TestMergeContextKeepsOptions(t *testing.T) {
+	opts := make([]merge.Option, 1, 2)
+	opts[0] = merge.WithOverwrite()
+	dst := simpleTest{Value: 1}
+	if err := merge.MergeContext(context.Background(), &dst, simpleTest{Value: 2}, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if err := merge.MapContext(context.Background(), &dst, map[string]interface{}{"value": 3}, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if opts[:2][1] != nil || dst.Value != 3 {
+		t.Errorf("the spare capacity of opts should be left alone, got %v and %#v", opts[:2][1] != nil, dst)
+	}
+}
+
+func TestMapContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var dst simpleTest
+	err := merge.MapContext(ctx, &dst, map[string]interface{}{"value": 42})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if dst.Value != 0 {
+		t.Errorf("dst should be untouched when ctx is done before the merge starts, got %#v", dst)
+	}
+}
+
+func TestWithMaxSliceLen(t *testing.T) {
+	type config struct {
+		Hosts map[string][]string
+	}
+	dst := config{Hosts: map[string][]string{"web": {"a", "b"}}}
+	src := config{Hosts: map[string][]string{"web": {"c", "d"}}}
+	if err := merge.Merge(&dst, src, merge.WithAppendSlice(), merge.WithMaxSliceLen(4)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := merge.Merge(&dst, src, merge.WithAppendSlice(), merge.WithMaxSliceLen(4))
+	var limitErr *merge.LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, merge.ErrLimitExceeded) {
+		t.Fatalf("expected a *LimitError, got %v", err)
+	}
+	if limitErr.Path != "hosts.web" || limitErr.Limit != "slice length" || limitErr.Max != 4 {
+		t.Errorf("unexpected error: %#v", limitErr)
+	}
+	if len(dst.Hosts["web"]) != 4 {
+		t.Errorf("slice over the limit should not be written, got %v", dst.Hosts["web"])
+	}
+}
+
+func TestWithMaxMapKeys(t *testing.T) {
+	dst := map[string]interface{}{"a": 1, "b": 2}
+	src := map[string]interface{}{"b": 3, "c": map[string]interface{}{"x": 1, "y": 2, "z": 3}}
+	err := merge.Merge(&dst, src, merge.WithMaxMapKeys(3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = merge.Merge(&dst, map[string]interface{}{"d": 4}, merge.WithMaxMapKeys(3))
+	var limitErr *merge.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Path != "" || limitErr.Limit != "map keys" {
+		t.Fatalf("expected a map keys *LimitError at the root, got %v", err)
+	}
+	if _, ok := dst["d"]; ok {
+		t.Errorf("key over the limit should not be added, got %v", dst)
+	}
+	err = merge.Merge(&dst, map[string]interface{}{"e": map[string]interface{}{"1": 1, "2": 2, "3": 3, "4": 4, "5": 5}}, merge.WithMaxMapKeys(4))
+	if !errors.As(err, &limitErr) || limitErr.Path != "e" {
+		t.Fatalf("expected a map keys *LimitError at e, got %v", err)
+	}
+}
+
+func TestWithMaxElements(t *testing.T) {
+	type config struct {
+		Name  string
+		Ports []int
+		Inner struct{ A, B, C int }
+	}
+	var dst config
+	src := config{Name: "web"}
+	if err := merge.Merge(&dst, src, merge.WithMaxElements(7)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := merge.Merge(&dst, src, merge.WithMaxElements(6))
+	var limitErr *merge.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Path != "inner.c" || limitErr.Limit != "elements" {
+		t.Fatalf("expected an elements *LimitError at inner.c, got %v", err)
+	}
+}
+
+func TestWithMaxBytes(t *testing.T) {
+	dst := map[string]string{}
+	src := map[string]string{"a": strings.Repeat("x", 100), "b": strings.Repeat("y", 100)}
+	err := merge.Merge(&dst, src, merge.WithMaxBytes(150))
+	var limitErr *merge.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "bytes" || limitErr.Max != 150 {
+		t.Fatalf("expected a bytes *LimitError, got %v", err)
+	}
+	if len(dst) != 1 {
+		t.Errorf("only the values under the limit should be written, got %d", len(dst))
+	}
+	dst = map[string]string{}
+	if err := merge.Merge(&dst, src, merge.WithMaxBytes(1000)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWithHook(t *testing.T) {
+	type credentials struct {
+		User     string
+		Password string
+	}
+	type config struct {
+		Name        string
+		Replicas    int
+		Credentials credentials
+		Labels      map[string]string
+	}
+	dst := config{Replicas: 1, Labels: map[string]string{"app": "web"}}
+	src := config{
+		Name:        "web",
+		Replicas:    3,
+		Credentials: credentials{User: "admin", Password: "hunter2"},
+		Labels:      map[string]string{"tier": "front"},
+	}
+	var events []string
+	err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithHook(func(ev merge.HookEvent) error {
+		events = append(events, fmt.Sprintf("%s %s %s", ev.Phase, ev.Action, ev.Path))
+		switch {
+		case ev.Path == "replicas" && ev.Action == merge.ActionSet:
+			return merge.SkipNode
+		case ev.Path == "credentials.password" && ev.Phase == merge.HookBefore:
+			ev.Replace("[redacted]")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := config{
+		Name:        "web",
+		Replicas:    1,
+		Credentials: credentials{User: "admin", Password: "[redacted]"},
+		Labels:      map[string]string{"app": "web", "tier": "front"},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+	for _, event := range []string{
+		"before merge ",
+		"before set name",
+		"after set name",
+		"before set replicas",
+		"before merge credentials.password",
+		"before set credentials.password",
+		"after merge credentials.password",
+		"before set labels.tier",
+		"after set labels.tier",
+		"after merge ",
+	} {
+		found := false
+		for _, e := range events {
+			found = found || e == event
+		}
+		if !found {
+			t.Errorf("missing event %q in %q", event, events)
+		}
+	}
+	for _, e := range events {
+		if e == "after set replicas" {
+			t.Errorf("skipped write should have no after event")
+		}
+	}
+}
+
+func TestWithHookReplaceMapEntry(t *testing.T) {
+	dst := map[string]interface{}{"db": map[string]interface{}{"host": "a", "port": 1}, "name": "app"}
+	src := map[string]interface{}{"db": map[string]interface{}{"host": "b"}}
+	var events []string
+	err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithHook(func(ev merge.HookEvent) error {
+		if ev.Path == "db" {
+			events = append(events, fmt.Sprintf("%s %s", ev.Phase, ev.Action))
+			if ev.Phase == merge.HookBefore && ev.Action == merge.ActionMerge {
+				ev.Replace(map[string]interface{}{"host": "c"})
+			}
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{"db": map[string]interface{}{"host": "c"}, "name": "app"}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+	if expected := []string{"before merge", "before set", "after set", "after merge"}; !reflect.DeepEqual(events, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", events, expected)
+	}
+
+	type server struct{ Host string }
+	servers := map[string]*server{"a": {Host: "a"}}
+	err = merge.Merge(&servers, map[string]*server{"a": {Host: "b"}}, merge.WithOverwrite(), merge.WithHook(func(ev merge.HookEvent) error {
+		if ev.Path == "a" && ev.Phase == merge.HookBefore && ev.Action == merge.ActionMerge {
+			ev.Replace(&server{Host: "c"})
+		}
+		return nil
+	}))
+	if err != nil || servers["a"].Host != "c" {
+		t.Errorf("expected the pointer entry replaced, got %v and %#v", err, servers["a"])
+	}
+
+	// Entries merged concurrently are replaced once all of them are merged.
+	servers, more := map[string]*server{}, map[string]*server{}
+	for i := 0; i < 2048; i++ {
+		key := strconv.Itoa(i)
+		servers[key], more[key] = &server{Host: "a"}, &server{Host: "b"}
+	}
+	err = merge.Merge(&servers, more, merge.WithOverwrite(), merge.WithConcurrency(4), merge.WithHook(func(ev merge.HookEvent) error {
+		if ev.Path == "7" && ev.Phase == merge.HookBefore && ev.Action == merge.ActionMerge {
+			ev.Replace(&server{Host: "c"})
+		}
+		return nil
+	}))
+	if err != nil || servers["7"].Host != "c" || servers["8"].Host != "b" {
+		t.Errorf("expected the concurrent pointer entry replaced, got %v, %#v and %#v", err, servers["7"], servers["8"])
+	}
+}
+
+func TestWithHookErrors(t *testing.T) {
+	errVeto := errors.New("veto")
+	dst := simpleTest{Value: 1}
+	err := merge.Merge(&dst, simpleTest{Value: 2}, merge.WithOverwrite(), merge.WithHook(func(ev merge.HookEvent) error {
+		if ev.Action == merge.ActionSet {
+			return errVeto
+		}
+		return nil
+	}))
+	if !errors.Is(err, errVeto) || dst.Value != 1 {
+		t.Errorf("expected the hook error and dst untouched, got %v and %#v", err, dst)
+	}
+	err = merge.Merge(&dst, simpleTest{Value: 2}, merge.WithOverwrite(), merge.WithHook(func(ev merge.HookEvent) error {
+		if ev.Path == "value" {
+			ev.Replace("two")
+		}
+		return nil
+	}))
+	if !errors.Is(err, merge.ErrDifferentArgumentsTypes) {
+		t.Errorf("expected ErrDifferentArgumentsTypes for a replacement of the wrong type, got %v", err)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	type config struct {
+		Name string
+		Tags []string
+	}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	dst := config{Name: "web", Tags: []string{"a"}}
+	if err := merge.Merge(&dst, config{Name: "api"}, merge.WithOverwrite(), merge.WithLogger(logger)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decisions := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		decisions[record["path"].(string)] = record
+	}
+	if tags := decisions["tags"]; tags["action"] != "keep" || tags["srcEmpty"] != true || tags["overwrite"] != true || tags["overwriteSliceWithEmptyValue"] != false {
+		t.Errorf("unexpected decision for tags: %v", tags)
+	}
+	if name := decisions["name"]; name["action"] != "set" || name["kind"] != "string" {
+		t.Errorf("unexpected decision for name: %v", name)
+	}
+
+	buf.Reset()
+	logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	if err := merge.Merge(&dst, config{Name: "api"}, merge.WithOverwrite(), merge.WithLogger(logger)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("nothing should be logged above debug level, got %s", buf.String())
+	}
+}
+
+type statsCounters map[string]float64
+
+func (c statsCounters) ExportCounter(name string, value float64) {
+	c[name] = value
+}
+
+func TestWithStats(t *testing.T) {
+	type config struct {
+		Name   string
+		Port   int
+		Hosts  []string
+		Labels map[string]string
+	}
+	var stats merge.Stats
+	dst := config{Name: "web", Hosts: []string{"a"}, Labels: map[string]string{"app": "web"}}
+	src := config{Name: "api", Port: 8080, Hosts: []string{"b"}, Labels: map[string]string{"app": "api", "tier": "front"}}
+	if err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithAppendSlice(), merge.WithStats(&stats)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var other struct{ Name string }
+	if err := merge.Map(&other, map[string]interface{}{"name": "db"}, merge.WithStats(&stats)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	snapshot := stats.Snapshot()
+	expected := merge.StatsSnapshot{
+		Merges:      2,
+		Visited:     7,
+		Written:     6,
+		Overwritten: 2,
+		KeysAdded:   1,
+		Appended:    1,
+		Duration:    snapshot.Duration,
+	}
+	if snapshot != expected {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", snapshot, expected)
+	}
+	if snapshot.Duration <= 0 {
+		t.Errorf("expected the time spent in merges, got %v", snapshot.Duration)
+	}
+
+	counters := make(statsCounters)
+	stats.Export(counters)
+	if counters["merges"] != 2 || counters["keys_added"] != 1 || counters["duration_seconds"] != snapshot.Duration.Seconds() {
+		t.Errorf("unexpected exported counters: %v", counters)
+	}
+	var exported merge.StatsSnapshot
+	if err := json.Unmarshal([]byte(merge.ExpvarStats(&stats).String()), &exported); err != nil || exported != snapshot {
+		t.Errorf("unexpected expvar value %v: %v", exported, err)
+	}
+}
+
+func TestWithStatsKeysDeleted(t *testing.T) {
+	var stats merge.Stats
+	dst := map[string]interface{}{"a": 1, "b": 2, "c": 3}
+	src := map[string]interface{}{"a": nil, "b": 4, "d": map[string]interface{}{"y": 1}}
+	err := merge.Merge(&dst, src, merge.WithOverwriteWithEmptyValue(), merge.WithInclude("a", "b", "d.x"), merge.WithStats(&stats),
+		merge.WithHook(func(ev merge.HookEvent) error {
+			if ev.Phase == merge.HookBefore && ev.Path == "b" {
+				ev.Replace(nil)
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{"a": nil, "b": nil, "c": 3}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+	if snapshot := stats.Snapshot(); snapshot.KeysAdded != 1 || snapshot.KeysDeleted != 3 {
+		t.Errorf("expected 1 key added and 3 deleted, got %d and %d", snapshot.KeysAdded, snapshot.KeysDeleted)
+	}
+}
+
+func TestWithDeterministicOrder(t *testing.T) {
+	src := map[interface{}]interface{}{"b": 1, "a": 2, 10: 3, 2: 4, true: 5, false: 6, 1.5: 7}
+	for i := 0; i < 10; i++ {
+		var paths []string
+		dst := map[interface{}]interface{}{}
+		err := merge.Merge(&dst, src, merge.WithDeterministicOrder(), merge.WithHook(func(ev merge.HookEvent) error {
+			if ev.Phase == merge.HookBefore && ev.Action == merge.ActionSet {
+				paths = append(paths, ev.Path)
+			}
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"false", "true", "2", "10", "1.5", "a", "b"}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Fatalf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", paths, expected)
+		}
+	}
+}
+
+func TestWithDeterministicOrderPointerKeys(t *testing.T) {
+	type key struct {
+		Name string
+		Next *key
+	}
+	src := make(map[*key]int)
+	for i := 5; i > 0; i-- {
+		src[&key{Name: fmt.Sprint(i), Next: &key{Name: "next"}}] = i
+	}
+	var seen []int
+	dst := map[*key]int{}
+	err := merge.Merge(&dst, src, merge.WithDeterministicOrder(), merge.WithHook(func(ev merge.HookEvent) error {
+		if ev.Phase == merge.HookBefore && ev.Action == merge.ActionSet {
+			seen = append(seen, int(ev.Src.Int()))
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(seen, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", seen, expected)
+	}
+}
+
+func TestWithDeterministicOrderErrors(t *testing.T) {
+	src := map[string]int{}
+	for i := 0; i < 100; i++ {
+		src[fmt.Sprintf("key%02d", i)] = i
+	}
+	for i := 0; i < 10; i++ {
+		dst := map[string]int{}
+		err := merge.Merge(&dst, src, merge.WithDeterministicOrder(), merge.WithMaxMapKeys(50))
+		var limitErr *merge.LimitError
+		if !errors.As(err, &limitErr) || limitErr.Path != "" || len(dst) != 50 || dst["key49"] != 49 {
+			t.Fatalf("expected the first 50 keys in order before the limit, got %v and %d keys", err, len(dst))
+		}
+	}
+}
+
+func TestSetOps(t *testing.T) {
+	type plugins struct {
+		Enabled  map[string]struct{} `merge:"set=subtract"`
+		Features map[string]bool     `merge:"set=intersect"`
+		Tags     []string            `merge:"set=xor"`
+		Hosts    []string
+	}
+	dst := plugins{
+		Enabled:  map[string]struct{}{"auth": {}, "cache": {}, "metrics": {}},
+		Features: map[string]bool{"a": true, "b": true, "c": false},
+		Tags:     []string{"x", "y"},
+		Hosts:    []string{"h1", "h2"},
+	}
+	src := plugins{
+		Enabled:  map[string]struct{}{"cache": {}},
+		Features: map[string]bool{"b": true, "c": true},
+		Tags:     []string{"y", "z"},
+		Hosts:    []string{"h2", "h3"},
+	}
+	if err := merge.Merge(&dst, src, merge.WithSetOp(merge.SetUnion, "hosts")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := plugins{
+		Enabled:  map[string]struct{}{"auth": {}, "metrics": {}},
+		Features: map[string]bool{"b": true},
+		Tags:     []string{"x", "z"},
+		Hosts:    []string{"h1", "h2", "h3"},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+
+	if err := merge.Merge(&dst, plugins{}); err != nil || !reflect.DeepEqual(dst, expected) {
+		t.Errorf("nil sets in src should leave dst as it is, got %#v (%v)", dst, err)
+	}
+}
+
+func TestSetOpsByPath(t *testing.T) {
+	dst := map[string]interface{}{
+		"plugins": []interface{}{"auth", "cache", "metrics"},
+		"name":    "default",
+	}
+	src := map[string]interface{}{
+		"plugins": []interface{}{"cache"},
+	}
+	if err := merge.Merge(&dst, src, merge.WithSetOp(merge.SetSubtract, "plugins")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []interface{}{"auth", "metrics"}; !reflect.DeepEqual(dst["plugins"], expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst["plugins"], expected)
+	}
+
+	var cfg struct {
+		Plugins []string `merge:"set=union"`
+	}
+	cfg.Plugins = []string{"auth"}
+	if err := merge.Map(&cfg, map[string]interface{}{"plugins": []interface{}{"cache", "auth"}}, merge.WithCoercion()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"auth", "cache"}; !reflect.DeepEqual(cfg.Plugins, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", cfg.Plugins, expected)
+	}
+
+	err := merge.Merge(&dst, map[string]interface{}{"name": "other"}, merge.WithSetOp(merge.SetUnion, "name"))
+	if !errors.Is(err, merge.ErrNotSet) {
+		t.Errorf("expected ErrNotSet, got %v", err)
+	}
+	var bad struct {
+		Plugins []string `merge:"set=merge"`
+	}
+	if err := merge.Merge(&bad, bad); err == nil || !strings.Contains(err.Error(), `plugins: unknown set operation "merge"`) {
+		t.Errorf("expected an unknown set operation error, got %v", err)
+	}
+}
+
+func TestLeafStrategies(t *testing.T) {
+	type quota struct {
+		Requests int64         `merge:"leaf=sum"`
+		Peak     float64       `merge:"leaf=max"`
+		Latency  time.Duration `merge:"leaf=min"`
+		Load     uint8         `merge:"leaf=avg"`
+		Limit    int
+	}
+	dst := quota{Requests: 10, Peak: 1.5, Latency: 30 * time.Millisecond, Load: 3, Limit: 5}
+	src := quota{Requests: 5, Peak: 2.5, Latency: 20 * time.Millisecond, Load: 6, Limit: 7}
+	if err := merge.Merge(&dst, src, merge.WithLeafStrategy(merge.Max, "limit")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := quota{Requests: 15, Peak: 2.5, Latency: 20 * time.Millisecond, Load: 4, Limit: 7}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+
+	// Empty leaves are merged as usual.
+	dst = quota{Latency: 0}
+	if err := merge.Merge(&dst, quota{Latency: time.Second}); err != nil || dst.Latency != time.Second {
+		t.Errorf("expected the src latency, got %v (%v)", dst.Latency, err)
+	}
+}
+
+func TestLeafStrategiesAverage(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, expected int64
+	}{
+		{3, 4, 3},
+		{-3, -4, -3},
+		{-3, 4, 0},
+		{-1, 2, 0},
+		{3, -4, 0},
+		{-3, 0, -1},
+		{-4, -1, -2},
+		{math.MaxInt64, math.MaxInt64, math.MaxInt64},
+		{math.MinInt64, math.MinInt64, math.MinInt64},
+		{math.MinInt64, math.MaxInt64, 0},
+	} {
+		v, err := merge.Average.MergeLeaf(reflect.ValueOf(tc.a), reflect.ValueOf(tc.b))
+		if err != nil || v.Int() != tc.expected {
+			t.Errorf("Average(%d, %d): got %v (%v), want %d", tc.a, tc.b, v, err, tc.expected)
+		}
+	}
+}
+
+func TestLeafStrategiesByType(t *testing.T) {
+	dst := map[string]interface{}{"cpu": 1.5, "requests": 10, "name": "web"}
+	src := map[string]interface{}{"cpu": 2.0, "requests": 5, "name": "api"}
+	err := merge.Merge(&dst, src, merge.WithOverwrite(),
+		merge.WithTypeLeafStrategy(reflect.TypeOf(0), merge.Sum),
+		merge.WithTypeLeafStrategy(reflect.TypeOf(0.0), merge.Average))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{"cpu": 1.75, "requests": 15, "name": "api"}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func TestLeafStrategiesOverflow(t *testing.T) {
+	type counters struct {
+		Small int8   `merge:"leaf=sum"`
+		Big   uint64 `merge:"leaf=sum"`
+	}
+	for _, tc := range []struct {
+		dst, src counters
+		path     string
+	}{
+		{counters{Small: 100}, counters{Small: 100}, "small"},
+		{counters{Small: -100}, counters{Small: -100}, "small"},
+		{counters{Big: math.MaxUint64}, counters{Big: 1}, "big"},
+	} {
+		err := merge.Merge(&tc.dst, tc.src)
+		var pathErr *merge.PathError
+		if !errors.Is(err, merge.ErrOverflow) || !errors.As(err, &pathErr) || pathErr.Path != tc.path {
+			t.Errorf("expected ErrOverflow at %s, got %v", tc.path, err)
+		}
+	}
+	dst := struct{ Name string }{"a"}
+	err := merge.Merge(&dst, struct{ Name string }{"b"}, merge.WithLeafStrategy(merge.Sum, "name"))
+	if !errors.Is(err, merge.ErrNotNumber) {
+		t.Errorf("expected ErrNotNumber, got %v", err)
+	}
+}
+
+func TestStringLeafStrategies(t *testing.T) {
+	type env struct {
+		Path     string `merge:"leaf=prepend,sep=:"`
+		JavaOpts string `merge:"leaf=concat,sep=' '"`
+		Selector string `merge:"leaf=append"`
+		Image    string `merge:"leaf=template,template={{.dst}}:{{.src}}"`
+		Name     string
+	}
+	dst := env{Path: "/usr/bin:/bin", JavaOpts: "-Xmx1g", Selector: "app=web,tier=front", Image: "nginx", Name: "web"}
+	src := env{Path: "/opt/bin", JavaOpts: "-Dfoo=bar", Selector: "tier=front", Image: "1.25", Name: "api"}
+	if err := merge.Merge(&dst, src, merge.WithLeafStrategy(merge.Concat("-"), "name")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := env{
+		Path:     "/opt/bin:/usr/bin:/bin",
+		JavaOpts: "-Xmx1g -Dfoo=bar",
+		Selector: "app=web,tier=front",
+		Image:    "nginx:1.25",
+		Name:     "web-api",
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+	if err := merge.Merge(&dst, env{Path: "/usr/bin"}); err != nil || dst.Path != expected.Path {
+		t.Errorf("items already in dst should not be added again, got %q (%v)", dst.Path, err)
+	}
+
+	labels := map[string]interface{}{"selector": "app=web"}
+	err := merge.Merge(&labels, map[string]interface{}{"selector": "env=prod"}, merge.WithLeafStrategy(merge.Template("{{.dst}},{{.src}}"), "selector"))
+	if err != nil || labels["selector"] != "app=web,env=prod" {
+		t.Errorf("unexpected selector %q (%v)", labels["selector"], err)
+	}
+	err = merge.Merge(&labels, map[string]interface{}{"selector": "x"}, merge.WithLeafStrategy(merge.Template("{{.dst"), "selector"))
+	var pathErr *merge.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "selector" {
+		t.Errorf("expected the template error at selector, got %v", err)
+	}
+	err = merge.Merge(&struct{ N int }{1}, struct{ N int }{2}, merge.WithLeafStrategy(merge.Concat(","), "n"))
+	if !errors.Is(err, merge.ErrNotString) {
+		t.Errorf("expected ErrNotString, got %v", err)
+	}
+}
+
+func TestStringLeafStrategiesQuotedTag(t *testing.T) {
+	type labels struct {
+		Selector string `merge:"leaf=template,template='{{.dst}},{{.src}}'"`
+		Hosts    string `merge:"leaf=append,sep=','"`
+		Owners   string `merge:"leaf=concat, sep=' & '"`
+		Quoted   string `merge:"leaf=concat,sep='it''s'"`
+	}
+	dst := labels{Selector: "app=web", Hosts: "a,b", Owners: "ops", Quoted: "x"}
+	src := labels{Selector: "env=prod", Hosts: "c", Owners: "dev", Quoted: "y"}
+	if err := merge.Merge(&dst, src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := labels{Selector: "app=web,env=prod", Hosts: "a,b,c", Owners: "ops & dev", Quoted: "xit'sy"}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	type server struct {
+		Host string
+		Port int
+	}
+	type config struct {
+		Server   server
+		Addr     string
+		Health   string
+		Token    *string
+		Literal  string
+		Backends []string
+		Labels   map[string]string
+	}
+	env := func(name string) (string, bool) {
+		if name == "TOKEN" {
+			return "s3cr3t", true
+		}
+		return "", false
+	}
+	token := "Bearer ${env:TOKEN}"
+	dst := config{
+		Server:   server{Host: "localhost", Port: 8080},
+		Addr:     "http://${server.host}:${server.port}",
+		Health:   "${addr}/healthz",
+		Literal:  "$${server.host}",
+		Backends: []string{"${server.host}:9000"},
+		Labels:   map[string]string{"url": "${ addr }"},
+	}
+	src := config{Server: server{Host: "example.com"}, Token: &token}
+	if err := merge.Merge(&dst, src, merge.WithOverwrite(), merge.WithInterpolation(merge.WithLookupEnv(env))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := config{
+		Server:   server{Host: "example.com", Port: 8080},
+		Addr:     "http://example.com:8080",
+		Health:   "http://example.com:8080/healthz",
+		Literal:  "${server.host}",
+		Backends: []string{"example.com:9000"},
+		Labels:   map[string]string{"url": "http://example.com:8080"},
+	}
+	if *dst.Token != "Bearer s3cr3t" {
+		t.Errorf("unexpected token %q", *dst.Token)
+	}
+	dst.Token = nil
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", dst, expected)
+	}
+
+	tree := map[string]interface{}{
+		"server": map[string]interface{}{"host": "db", "port": 5432},
+		"dsn":    "postgres://${server.host}:${server.port}",
+		"hosts":  []interface{}{"${server.host}"},
+		"nested": map[string]server{"primary": {Host: "${server.host}"}},
+	}
+	if err := merge.Interpolate(tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tree["dsn"] != "postgres://db:5432" || tree["hosts"].([]interface{})[0] != "db" || tree["nested"].(map[string]server)["primary"].Host != "db" {
+		t.Errorf("unexpected tree %#v", tree)
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		tree map[string]interface{}
+		path string
+		err  error
+	}{
+		{map[string]interface{}{"a": "${b}"}, "a", merge.ErrMissingReference},
+		{map[string]interface{}{"a": "${env:MISSING}"}, "a", merge.ErrMissingReference},
+		{map[string]interface{}{"a": "${b}", "b": "${c}", "c": "${a}"}, "a", merge.ErrReferenceCycle},
+		{map[string]interface{}{"a": "${a}"}, "a", merge.ErrReferenceCycle},
+		{map[string]interface{}{"a": "x", "b": "${a"}, "b", merge.ErrInvalidReference},
+		{map[string]interface{}{"a": "${}"}, "a", merge.ErrInvalidReference},
+		{map[string]interface{}{"a": "${m}", "m": map[string]interface{}{}}, "a", merge.ErrInvalidReference},
+	} {
+		err := merge.Interpolate(tc.tree, merge.WithLookupEnv(func(string) (string, bool) { return "", false }))
+		var pathErr *merge.PathError
+		if !errors.Is(err, tc.err) || !errors.As(err, &pathErr) || pathErr.Path != tc.path {
+			t.Errorf("expected %v at %s, got %v", tc.err, tc.path, err)
+		}
+	}
+	err := merge.Interpolate(map[string]interface{}{"a": "${b}", "b": "${c}", "c": "${a}"})
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Errorf("expected the cycle to be spelled out, got %v", err)
+	}
+	if err := merge.Interpolate(struct{ A string }{"${b}"}); !errors.Is(err, merge.ErrNonPointerAgument) {
+		t.Errorf("expected ErrNonPointerAgument, got %v", err)
+	}
+}
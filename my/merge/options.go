@@ -1,9 +1,13 @@
 package merge
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
+
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -30,6 +34,36 @@ type Options struct {
 	appendSlice                  bool
 	typeCheck                    bool
 	overwriteRecursively         bool
+	defaultsFromTags             bool
+	coerce                       bool
+	lenientKeys                  bool
+	checkRequiredFields          bool
+	concurrency                  int
+	deterministicOrder           bool
+	skipPaths                    bool
+
+	include   []pathPattern
+	exclude   []pathPattern
+	immutable []pathPattern
+	writeOnce []pathPattern
+	required  []pathPattern
+	mergeKeys []string
+	setOps    []pathSetOp
+	leafPaths []pathLeafStrategy
+	leafTypes map[reflect.Type]LeafStrategy
+
+	ctx    context.Context
+	steps  int
+	limits *limits
+	hooks  []func(ev HookEvent) error
+	logger *slog.Logger
+	stats  *Stats
+
+	provenance *Provenance
+	origin     Origin
+	sourceNode *yaml.Node
+
+	interpolation *interpolation
 
 	Strategies map[Range]strategy
 }
@@ -77,6 +111,117 @@ func WithOverwriteRecursively() Option {
 	}
 }
 
+// WithMergeKeys will make merge match the elements of slices of maps or structs by the value of
+// the first of keys that every element has, e.g. "name": matching elements are merged and the
+// others appended, instead of replacing or appending the whole slice.
+func WithMergeKeys(keys ...string) Option {
+	return func(config *Options) {
+		config.mergeKeys = append(config.mergeKeys, keys...)
+	}
+}
+
+// WithConcurrency will make merge use up to n goroutines for maps of pointers with many
+// entries, and for slices of structs or pointers with many elements that merge walks
+// element by element, which it does when WithInclude or WithExclude patterns reach
+// below them. Entries are merged concurrently when they do not share pointers; slices
+// replaced, appended or merged by key as a whole are merged sequentially.
+// Transformers must then be safe for concurrent use.
+// The result is the one of a sequential merge; when entries fail to merge, the error
+// of the lowest key or index is returned.
+func WithConcurrency(n int) Option {
+	return func(config *Options) {
+		config.concurrency = n
+	}
+}
+
+// WithDefaultsFromTags will make merge fill the fields still empty once it is done
+// with their `default:"..."` tag, see ApplyDefaults.
+func WithDefaultsFromTags() Option {
+	return func(config *Options) {
+		config.defaultsFromTags = true
+	}
+}
+
+// WithCoercion will make Map convert src values that do not have the type of their field,
+// e.g. "8080" into an int or "30s" into a time.Duration, following the rules of coerceValue.
+// Values that cannot be converted are left to the usual Map rules.
+func WithCoercion() Option {
+	return func(config *Options) {
+		config.coerce = true
+	}
+}
+
+// WithLenientKeys will make Map find the field of a src key by its yaml or json tag
+// name, or ignoring case, underscores and dashes, so "pool_size" and "pool-size" land
+// on PoolSize, as in configuration files and the trees built by FromEnv and FromFlagSet.
+// Without it a key only matches a field name or its lower camel case form.
+func WithLenientKeys() Option {
+	return func(config *Options) {
+		config.lenientKeys = true
+	}
+}
+
+// WithInclude restricts merge to the paths matched by patterns and their descendants.
+// Patterns are dotted paths where "*" matches one segment and "**" any number of them,
+// e.g. "spec.containers.*.image" or "metadata.labels.**". Everything else is left in dst as it is.
+func WithInclude(patterns ...string) Option {
+	return func(config *Options) {
+		config.include = append(config.include, compilePatterns(patterns)...)
+	}
+}
+
+// WithExclude keeps merge away from the paths matched by patterns and their descendants.
+// It takes the same patterns as WithInclude and wins over it.
+func WithExclude(patterns ...string) Option {
+	return func(config *Options) {
+		config.exclude = append(config.exclude, compilePatterns(patterns)...)
+	}
+}
+
+// WithImmutablePaths marks the values matched by patterns as immutable, like the `merge:"immutable"` tag:
+// merge returns a *PathError wrapping ErrImmutableField when it would change the value they hold.
+func WithImmutablePaths(patterns ...string) Option {
+	return func(config *Options) {
+		config.immutable = append(config.immutable, compilePatterns(patterns)...)
+	}
+}
+
+// WithWriteOncePaths marks the values matched by patterns as write-once, like the `merge:"writeonce"` tag:
+// they may be filled while empty, after which merge returns a *PathError wrapping ErrWriteOnceField
+// when src tries to change them.
+func WithWriteOncePaths(patterns ...string) Option {
+	return func(config *Options) {
+		config.writeOnce = append(config.writeOnce, compilePatterns(patterns)...)
+	}
+}
+
+// WithRequired will make merge check, once done, the fields tagged `merge:"required"` and the values
+// matched by patterns: every one of them still empty is reported in a *MissingFieldsError.
+// Only give it to the last of a series of merges, earlier ones are expected to leave gaps.
+func WithRequired(patterns ...string) Option {
+	return func(config *Options) {
+		config.checkRequiredFields = true
+		config.required = append(config.required, compilePatterns(patterns)...)
+	}
+}
+
+// WithProvenance will make merge record in prov every path it writes, attributed to source.
+func WithProvenance(prov *Provenance, source string) Option {
+	return func(config *Options) {
+		config.provenance = prov
+		config.origin.Source = source
+	}
+}
+
+// WithSourceNode tells WithProvenance that src was decoded from node, read from file,
+// so that recorded origins carry the file name and the line of each value.
+func WithSourceNode(file string, node *yaml.Node) Option {
+	return func(config *Options) {
+		config.origin.File = file
+		config.sourceNode = node
+	}
+}
+
 func WithStrategy(rng Range, style Style, isCover func(dst reflect.Value, src reflect.Value) bool) Option {
 	return func(config *Options) {
 		switch rng {
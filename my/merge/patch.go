@@ -0,0 +1,25 @@
+package merge
+
+// MergePatch applies a JSON Merge Patch (RFC 7386) to doc and returns the result.
+// Both are decoded JSON or YAML trees: objects are map[string]interface{}. A null
+// member of patch removes the member of doc, an object member is patched
+// recursively and any other member replaces the one of doc. The maps of doc are
+// modified in place.
+func MergePatch(doc, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	target, ok := doc.(map[string]interface{})
+	if !ok {
+		target = make(map[string]interface{}, len(fields))
+	}
+	for key, value := range fields {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		target[key] = MergePatch(target[key], value)
+	}
+	return target
+}
@@ -0,0 +1,129 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldPath is the dotted location of a value inside the tree being merged.
+// Struct fields use the lower camel case of their name, the same key Map uses,
+// map entries use their key and slice elements use their index.
+type fieldPath []string
+
+func (p fieldPath) child(name string) fieldPath {
+	return append(p[:len(p):len(p)], name)
+}
+
+func (p fieldPath) key(key reflect.Value) fieldPath {
+	return p.child(fmt.Sprint(key.Interface()))
+}
+
+func (p fieldPath) index(i int) fieldPath {
+	return p.child(fmt.Sprint(i))
+}
+
+func (p fieldPath) String() string {
+	return strings.Join(p, ".")
+}
+
+// PathError records an error and the path of the value that caused it.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// Building a path costs an allocation per value, merge only does it when something
+// reports or matches paths: an option, or a struct tag whose errors name the field.
+// Otherwise every path is nil.
+
+var typePaths sync.Map // map[reflect.Type]bool
+
+// usesPaths reports whether merging into a value of type typ with config needs paths.
+func (config *Options) usesPaths(typ reflect.Type) bool {
+	if config.provenance != nil || config.limits != nil || config.logger != nil || config.ctx != nil ||
+		len(config.hooks) > 0 || len(config.include) > 0 || len(config.exclude) > 0 ||
+		len(config.immutable) > 0 || len(config.writeOnce) > 0 || config.combines() {
+		return true
+	}
+	return typeHasTags(typ)
+}
+
+// typeHasTags is hasTaggedFields, cached by type.
+func typeHasTags(typ reflect.Type) bool {
+	if tagged, ok := typePaths.Load(typ); ok {
+		return tagged.(bool)
+	}
+	tagged := hasTaggedFields(typ, make(map[reflect.Type]bool))
+	typePaths.Store(typ, tagged)
+	return tagged
+}
+
+// hasTaggedFields reports whether a struct reachable from typ has a field with a merge tag.
+// Values held by interfaces are not known in advance, their tags are not looked at.
+func hasTaggedFields(typ reflect.Type, seen map[reflect.Type]bool) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasTaggedFields(typ.Elem(), seen)
+	case reflect.Struct:
+		if seen[typ] {
+			return false
+		}
+		seen[typ] = true
+		plan := planOf(typ)
+		for i := range plan.fields {
+			field := &plan.fields[i]
+			if field.exported && (len(field.tag) > 0 || hasTaggedFields(field.Type, seen)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checksField reports whether a field tagged with tag must go through the per-field
+// checks: filters, immutability and combined strategies. None of them has anything
+// to do when paths are skipped and the field has no tag.
+func (config *Options) checksField(tag tagOptions) bool {
+	return !config.skipPaths || tag != nil
+}
+
+func (config *Options) fieldPath(p fieldPath, field *fieldPlan) fieldPath {
+	if config.skipPaths {
+		return nil
+	}
+	return field.path(p)
+}
+
+func (config *Options) childPath(p fieldPath, name string) fieldPath {
+	if config.skipPaths {
+		return nil
+	}
+	return p.child(name)
+}
+
+func (config *Options) keyPath(p fieldPath, key reflect.Value) fieldPath {
+	if config.skipPaths {
+		return nil
+	}
+	return p.key(key)
+}
+
+func (config *Options) indexPath(p fieldPath, i int) fieldPath {
+	if config.skipPaths {
+		return nil
+	}
+	return p.index(i)
+}
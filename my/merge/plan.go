@@ -0,0 +1,121 @@
+package merge
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// structPlan is what merge needs to know about a struct type. It is computed once
+// per type and cached, as reflection on types is the bulk of the cost of a merge.
+type structPlan struct {
+	// mergeable is false when the struct has no exported field to merge into,
+	// merge then sets it as a whole.
+	mergeable bool
+	fields    []fieldPlan
+
+	keysOnce sync.Once
+	// byName, byTagName and byNormalizedName are the fields Map finds from src keys,
+	// see fieldByKey.
+	byName           map[string]fieldLookup
+	byTagName        map[string]fieldLookup
+	byNormalizedName map[string]fieldLookup
+}
+
+// fieldPlan is a field of a structPlan.
+type fieldPlan struct {
+	reflect.StructField
+	// exported fields, and embedded ones, are walked by the passes over structs.
+	exported bool
+	// segment is the name of the field in paths, empty for embedded fields.
+	segment string
+	tag     tagOptions
+}
+
+func (f *fieldPlan) path(p fieldPath) fieldPath {
+	if f.Anonymous {
+		return p
+	}
+	return p.child(f.segment)
+}
+
+type fieldLookup struct {
+	field reflect.StructField
+	tag   tagOptions
+}
+
+var structPlans sync.Map // map[reflect.Type]*structPlan
+
+// planOf returns the plan of the struct type typ.
+func planOf(typ reflect.Type) *structPlan {
+	if plan, ok := structPlans.Load(typ); ok {
+		return plan.(*structPlan)
+	}
+	plan := &structPlan{
+		mergeable: hasMergeableFields(typ),
+		fields:    make([]fieldPlan, typ.NumField()),
+	}
+	for i := range plan.fields {
+		field := typ.Field(i)
+		plan.fields[i] = fieldPlan{
+			StructField: field,
+			exported:    isExported(field) || field.Anonymous,
+			segment:     changeInitialCase(field.Name, unicode.ToLower),
+			tag:         parseTag(field),
+		}
+	}
+	actual, _ := structPlans.LoadOrStore(typ, plan)
+	return actual.(*structPlan)
+}
+
+// fieldByKey finds the exported field of the struct type of the plan a src map key lands in,
+// along with the tag of the field: the field named like the key with its initial in upper case
+// or, if lenient, the field whose yaml or json tag names the key, then the field whose name
+// matches the key ignoring case, underscores and dashes ("pool_size" for PoolSize).
+func (plan *structPlan) fieldByKey(typ reflect.Type, key string, lenient bool) (reflect.StructField, tagOptions, bool) {
+	plan.keysOnce.Do(func() { plan.indexKeys(typ) })
+	if lookup, ok := plan.byName[key]; ok {
+		return lookup.field, lookup.tag, true
+	}
+	if lenient {
+		if lookup, ok := plan.byTagName[key]; ok {
+			return lookup.field, lookup.tag, true
+		}
+		if lookup, ok := plan.byNormalizedName[normalizeKey(key)]; ok {
+			return lookup.field, lookup.tag, true
+		}
+	}
+	return reflect.StructField{}, nil, false
+}
+
+// indexKeys fills the tables of fieldByKey. A key finds the field typ.FieldByName finds for
+// the key with its initial in upper case, so both the field name and its lower camel case
+// form are indexed. Only the first of the fields sharing a normalized name is kept.
+func (plan *structPlan) indexKeys(typ reflect.Type) {
+	plan.byName = make(map[string]fieldLookup)
+	plan.byTagName = make(map[string]fieldLookup)
+	plan.byNormalizedName = make(map[string]fieldLookup)
+	for _, field := range reflect.VisibleFields(typ) {
+		if !isExported(field) {
+			continue
+		}
+		if found, ok := typ.FieldByName(field.Name); ok && reflect.DeepEqual(found.Index, field.Index) {
+			lookup := fieldLookup{field, parseTag(field)}
+			plan.byName[field.Name] = lookup
+			plan.byName[changeInitialCase(field.Name, unicode.ToLower)] = lookup
+			for _, key := range [...]string{"yaml", "json"} {
+				name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+				if _, ok := plan.byTagName[name]; name != "" && name != "-" && !ok {
+					plan.byTagName[name] = lookup
+				}
+			}
+		}
+		if field.Anonymous {
+			continue
+		}
+		if normalized := normalizeKey(field.Name); plan.byNormalizedName[normalized].field.Index == nil {
+			plan.byNormalizedName[normalized] = fieldLookup{field, parseTag(field)}
+		}
+	}
+}
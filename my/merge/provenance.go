@@ -0,0 +1,223 @@
+package merge
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Origin describes where a merged value came from.
+type Origin struct {
+	// Source is the name given to the merge that wrote the value.
+	Source string
+	// File, Line and Column are set when the source was read from a file.
+	File   string
+	Line   int
+	Column int
+}
+
+func (o Origin) String() string {
+	switch {
+	case o.File == "":
+		return o.Source
+	case o.Line == 0:
+		return fmt.Sprintf("%s (%s)", o.Source, o.File)
+	default:
+		return fmt.Sprintf("%s (%s:%d:%d)", o.Source, o.File, o.Line, o.Column)
+	}
+}
+
+type provenanceEntry struct {
+	origin Origin
+	node   *yaml.Node
+}
+
+// provenanceNode is a path segment of the recorded tree. Paths are indexed segment
+// by segment so that recording a path drops everything below it in one step.
+type provenanceNode struct {
+	entry    *provenanceEntry
+	children map[string]*provenanceNode
+}
+
+// Provenance records, for every path written by Merge or Map, which source
+// wrote it. The zero value is ready to use and the same Provenance can be
+// shared by every step of a multi-step merge.
+type Provenance struct {
+	mu   sync.Mutex
+	root provenanceNode
+}
+
+// NewProvenance returns an empty Provenance.
+func NewProvenance() *Provenance {
+	return &Provenance{}
+}
+
+// Origin returns the origin of the value at path, e.g. "db.pool.size".
+// When the value was written as part of a larger subtree, the origin of the
+// closest recorded ancestor is returned. Map records the keys of its source, so
+// segments also match ignoring case, underscores and dashes: "db.poolSize" finds
+// the value recorded at "db.pool_size".
+func (p *Provenance) Origin(path string) (Origin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	closest := p.root.entry
+	if path != "" {
+		node := &p.root
+		for _, segment := range strings.Split(path, ".") {
+			if node = node.child(segment); node == nil {
+				break
+			}
+			if node.entry != nil {
+				closest = node.entry
+			}
+		}
+	}
+	if closest == nil {
+		return Origin{}, false
+	}
+	origin := closest.origin
+	if line, column := yamlNodePosition(closest.node, path); line > 0 {
+		origin.Line, origin.Column = line, column
+	}
+	return origin, true
+}
+
+// Origins returns a copy of every recorded path and its origin.
+func (p *Provenance) Origins() map[string]Origin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	origins := make(map[string]Origin)
+	p.root.collect("", origins)
+	return origins
+}
+
+// child returns the child of n at segment or, failing that, the first one by name
+// whose segment matches ignoring case, underscores and dashes.
+func (n *provenanceNode) child(segment string) *provenanceNode {
+	if child, ok := n.children[segment]; ok {
+		return child
+	}
+	var found *provenanceNode
+	name := ""
+	normalized := normalizeKey(segment)
+	for key, child := range n.children {
+		if normalizeKey(key) == normalized && (found == nil || key < name) {
+			found, name = child, key
+		}
+	}
+	return found
+}
+
+func (n *provenanceNode) collect(path string, origins map[string]Origin) {
+	if n.entry != nil {
+		origins[path] = n.entry.origin
+	}
+	for segment, child := range n.children {
+		if path != "" {
+			segment = path + "." + segment
+		}
+		child.collect(segment, origins)
+	}
+}
+
+// Dump writes every recorded path and its origin to w, one per line, sorted by path.
+func (p *Provenance) Dump(w io.Writer) error {
+	origins := p.Origins()
+	paths := make([]string, 0, len(origins))
+	for path := range origins {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", path, origins[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Provenance) record(path string, origin Origin, node *yaml.Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	target := &p.root
+	if path != "" {
+		for _, segment := range strings.Split(path, ".") {
+			child := target.children[segment]
+			if child == nil {
+				if target.children == nil {
+					target.children = make(map[string]*provenanceNode)
+				}
+				child = &provenanceNode{}
+				target.children[segment] = child
+			}
+			target = child
+		}
+	}
+	// Whatever was recorded below path has just been replaced.
+	target.children = nil
+	if line, column := yamlNodePosition(node, path); line > 0 {
+		origin.Line, origin.Column = line, column
+	}
+	target.entry = &provenanceEntry{origin, node}
+}
+
+// yamlNodePosition returns the line and column of the value at path inside node.
+// Segments are matched to keys as by Provenance.Origin.
+func yamlNodePosition(node *yaml.Node, path string) (line, column int) {
+	if node == nil {
+		return 0, 0
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if path == "" {
+		return node.Line, node.Column
+	}
+	// Mapping entries are reported at their key, which is where humans look.
+	position := node
+	for _, segment := range strings.Split(path, ".") {
+		for node.Kind == yaml.AliasNode && node.Alias != nil {
+			node = node.Alias
+		}
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			if i := yamlKeyIndex(node, segment); i >= 0 {
+				position, next = node.Content[i], node.Content[i+1]
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(segment); err == nil && i >= 0 && i < len(node.Content) {
+				position, next = node.Content[i], node.Content[i]
+			}
+		}
+		if next == nil {
+			return 0, 0
+		}
+		node = next
+	}
+	return position.Line, position.Column
+}
+
+// yamlKeyIndex returns the index in the mapping node of the key segment, or of the
+// first key matching it ignoring case, underscores and dashes, -1 when there is none.
+func yamlKeyIndex(node *yaml.Node, segment string) int {
+	lenient := -1
+	normalized := normalizeKey(segment)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch key := node.Content[i].Value; {
+		case key == segment:
+			return i
+		case lenient < 0 && normalizeKey(key) == normalized:
+			lenient = i
+		}
+	}
+	return lenient
+}
@@ -0,0 +1,148 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ChangeOp is the kind of a Change.
+type ChangeOp int
+
+const (
+	ChangeAdd ChangeOp = iota
+	ChangeReplace
+	ChangeRemove
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeAdd:
+		return "add"
+	case ChangeReplace:
+		return "replace"
+	case ChangeRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("ChangeOp(%d)", int(op))
+	}
+}
+
+// MarshalText encodes op as its name.
+func (op ChangeOp) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+// UnmarshalText decodes the name of an op.
+func (op *ChangeOp) UnmarshalText(text []byte) error {
+	for _, o := range []ChangeOp{ChangeAdd, ChangeReplace, ChangeRemove} {
+		if o.String() == string(text) {
+			*op = o
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown change op %q", text)
+}
+
+// Change is a value that differs between two versions of a tree.
+type Change struct {
+	Op   ChangeOp    `json:"op"`
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Op {
+	case ChangeAdd:
+		return fmt.Sprintf("add %s: %v", c.Path, c.New)
+	case ChangeRemove:
+		return fmt.Sprintf("remove %s: %v", c.Path, c.Old)
+	default:
+		return fmt.Sprintf("replace %s: %v -> %v", c.Path, c.Old, c.New)
+	}
+}
+
+// Diff returns the changes that turn before into after, with the paths of Provenance.
+// Maps, in key order, and structs are compared member by member, slices element by
+// element when their lengths match and as a whole otherwise.
+func Diff(before, after interface{}) []Change {
+	var changes []Change
+	diff(reflect.ValueOf(before), reflect.ValueOf(after), nil, &changes)
+	return changes
+}
+
+func diff(before, after reflect.Value, path fieldPath, changes *[]Change) {
+	for before.Kind() == reflect.Interface || before.Kind() == reflect.Ptr {
+		if before.IsNil() {
+			before = reflect.Value{}
+			break
+		}
+		before = before.Elem()
+	}
+	for after.Kind() == reflect.Interface || after.Kind() == reflect.Ptr {
+		if after.IsNil() {
+			after = reflect.Value{}
+			break
+		}
+		after = after.Elem()
+	}
+
+	switch {
+	case !before.IsValid() && !after.IsValid():
+		return
+	case !before.IsValid():
+		*changes = append(*changes, Change{Op: ChangeAdd, Path: path.String(), New: after.Interface()})
+		return
+	case !after.IsValid():
+		*changes = append(*changes, Change{Op: ChangeRemove, Path: path.String(), Old: before.Interface()})
+		return
+	case before.Type() != after.Type():
+		*changes = append(*changes, Change{Op: ChangeReplace, Path: path.String(), Old: before.Interface(), New: after.Interface()})
+		return
+	}
+
+	switch before.Kind() {
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, key := range before.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+		for _, key := range after.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := keys[name]
+			diff(before.MapIndex(key), after.MapIndex(key), path.child(name), changes)
+		}
+		return
+	case reflect.Struct:
+		if before.Type() == timeType {
+			break
+		}
+		plan := planOf(before.Type())
+		for i := range plan.fields {
+			if field := &plan.fields[i]; field.IsExported() {
+				diff(before.Field(i), after.Field(i), field.path(path), changes)
+			}
+		}
+		return
+	case reflect.Slice, reflect.Array:
+		if before.Len() != after.Len() {
+			break
+		}
+		for i := 0; i < before.Len(); i++ {
+			diff(before.Index(i), after.Index(i), path.index(i), changes)
+		}
+		return
+	}
+	if !before.CanInterface() || reflect.DeepEqual(before.Interface(), after.Interface()) {
+		return
+	}
+	*changes = append(*changes, Change{Op: ChangeReplace, Path: path.String(), Old: before.Interface(), New: after.Interface()})
+}
@@ -0,0 +1,135 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrRequiredField is matched by the error returned when required values are missing.
+var ErrRequiredField = errors.New("required field is empty")
+
+// MissingFieldsError lists every required path that is still empty after a merge.
+type MissingFieldsError struct {
+	Paths []string
+}
+
+func (e *MissingFieldsError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrRequiredField, strings.Join(e.Paths, ", "))
+}
+
+func (e *MissingFieldsError) Is(target error) bool {
+	return target == ErrRequiredField
+}
+
+// CheckRequired reports, in a single *MissingFieldsError, every value of v that is
+// tagged `merge:"required"` or matched by one of paths and is empty as defined by merge.
+// Merge and Map run the same check when they are done if given WithRequired.
+func CheckRequired(v interface{}, paths ...string) error {
+	config := &Options{required: compilePatterns(paths)}
+	return config.checkRequired(reflect.ValueOf(v))
+}
+
+func (config *Options) checkRequired(v reflect.Value) error {
+	var missing []string
+	config.collectMissing(v, nil, make(map[uintptr]bool), &missing)
+	if len(missing) == 0 {
+		return nil
+	}
+	return &MissingFieldsError{Paths: missing}
+}
+
+// collectMissing appends to missing the required paths at or below path that are empty.
+// Tagged fields below a nil struct pointer are not required: the whole section is absent.
+func (config *Options) collectMissing(v reflect.Value, path fieldPath, seen map[uintptr]bool, missing *[]string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			config.collectMissingKeys(nil, path, missing)
+			return
+		}
+		// seen only holds the pointers being walked: a pointer reached again below
+		// itself is a cycle, one reached through another path is checked there too.
+		if seen[v.Pointer()] {
+			return
+		}
+		seen[v.Pointer()] = true
+		defer delete(seen, v.Pointer())
+		config.collectMissing(v.Elem(), path, seen, missing)
+	case reflect.Interface:
+		if !v.IsNil() {
+			config.collectMissing(v.Elem(), path, seen, missing)
+		} else {
+			config.collectMissingKeys(nil, path, missing)
+		}
+	case reflect.Struct:
+		plan := planOf(v.Type())
+		for i := range plan.fields {
+			field := &plan.fields[i]
+			if !field.exported {
+				continue
+			}
+			fieldPath := field.path(path)
+			if field.tag.has("required") || matchAny(config.required, fieldPath) {
+				if isEmptyValue(v.Field(i)) {
+					*missing = append(*missing, fieldPath.String())
+					continue
+				}
+			}
+			config.collectMissing(v.Field(i), fieldPath, seen, missing)
+		}
+	case reflect.Map:
+		if len(config.required) == 0 && !elemsMayBeTagged(v.Type()) {
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		present := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			keyPath := path.key(key)
+			present[keyPath[len(keyPath)-1]] = true
+			if matchAny(config.required, keyPath) && isEmptyValue(unwrapInterface(v.MapIndex(key))) {
+				*missing = append(*missing, keyPath.String())
+				continue
+			}
+			config.collectMissing(v.MapIndex(key), keyPath, seen, missing)
+		}
+		config.collectMissingKeys(present, path, missing)
+	case reflect.Slice, reflect.Array:
+		if len(config.required) == 0 && !elemsMayBeTagged(v.Type()) {
+			return
+		}
+		for i, n := 0, v.Len(); i < n; i++ {
+			config.collectMissing(v.Index(i), path.index(i), seen, missing)
+		}
+	}
+}
+
+// elemsMayBeTagged reports whether the elements of the map, slice or array type typ
+// may hold `merge:"required"` fields: they have tagged fields or are interfaces.
+func elemsMayBeTagged(typ reflect.Type) bool {
+	elem := typ.Elem()
+	return elem.Kind() == reflect.Interface || typeHasTags(elem)
+}
+
+// collectMissingKeys reports the required paths naming a child of path that is not present.
+// Only literal segments can be reported, a wildcard cannot say which child is missing.
+func (config *Options) collectMissingKeys(present map[string]bool, path fieldPath, missing *[]string) {
+	reported := make(map[string]bool)
+	for _, pattern := range config.required {
+		if len(pattern) <= len(path) || !matchSegments(pattern[:len(path)], path, false) {
+			continue
+		}
+		name := pattern[len(path)]
+		if present[name] || reported[name] || strings.ContainsAny(name, "*?[\\") {
+			continue
+		}
+		reported[name] = true
+		// Everything below a missing key is missing too, the key itself is reported.
+		*missing = append(*missing, path.child(name).String())
+	}
+}
@@ -0,0 +1,291 @@
+// Package server exposes merge over HTTP, so that programs not written in Go get
+// exactly the same merge semantics.
+//
+// A client POSTs the base document, the overlays and the options, either as a JSON
+// Request or as a multipart form with a "base" part, "overlay" parts in order and an
+// optional "options" part holding Options as JSON. Multipart documents may be JSON
+// or YAML, guessed from the file name or the content type. The response is a JSON
+// Response.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/cloudlibraries/merge"
+	"github.com/cloudlibraries/merge/loader"
+)
+
+// DefaultMaxBytes is the request size limit of a Handler without MaxBytes.
+const DefaultMaxBytes = 32 << 20
+
+// DefaultMaxElements is the limit of a Handler without MaxElements on the values
+// merging each overlay may visit, see merge.WithMaxElements. The JSON and YAML
+// decoders already reject documents nested more than 10000 levels deep.
+const DefaultMaxElements = 1 << 20
+
+// Options selects the merge strategy of a request.
+type Options struct {
+	Overwrite                    bool     `json:"overwrite"`
+	OverwriteWithEmptyValue      bool     `json:"overwriteWithEmptyValue"`
+	OverwriteSliceWithEmptyValue bool     `json:"overwriteSliceWithEmptyValue"`
+	AppendSlice                  bool     `json:"appendSlice"`
+	MergeKeys                    []string `json:"mergeKeys"`
+	Include                      []string `json:"include"`
+	Exclude                      []string `json:"exclude"`
+	// Patch applies the overlays as JSON Merge Patches (RFC 7386) instead.
+	Patch bool `json:"patch"`
+	// Report asks for the changes made to the base document.
+	Report bool `json:"report"`
+}
+
+func (o Options) mergeOptions() []merge.Option {
+	var opts []merge.Option
+	if o.Overwrite {
+		opts = append(opts, merge.WithOverwrite())
+	}
+	if o.OverwriteWithEmptyValue {
+		opts = append(opts, merge.WithOverwriteWithEmptyValue())
+	}
+	if o.OverwriteSliceWithEmptyValue {
+		opts = append(opts, merge.WithOverwriteSliceWithEmptyValue())
+	}
+	if o.AppendSlice {
+		opts = append(opts, merge.WithAppendSlice())
+	}
+	if len(o.MergeKeys) > 0 {
+		opts = append(opts, merge.WithMergeKeys(o.MergeKeys...))
+	}
+	if len(o.Include) > 0 {
+		opts = append(opts, merge.WithInclude(o.Include...))
+	}
+	if len(o.Exclude) > 0 {
+		opts = append(opts, merge.WithExclude(o.Exclude...))
+	}
+	return opts
+}
+
+// Request is the JSON body of a merge request.
+type Request struct {
+	Base     json.RawMessage   `json:"base"`
+	Overlays []json.RawMessage `json:"overlays"`
+	Options  Options           `json:"options"`
+}
+
+// Response is the JSON body of a successful merge.
+type Response struct {
+	Result  map[string]interface{} `json:"result"`
+	Changes []merge.Change         `json:"changes,omitempty"`
+}
+
+// ErrorResponse is the JSON body of a failed merge.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler merges the documents POSTed to it.
+type Handler struct {
+	// MaxBytes limits the size of request bodies, DefaultMaxBytes when zero.
+	MaxBytes int64
+	// MaxElements limits the values merging each overlay visits, DefaultMaxElements
+	// when zero. A merge.WithMaxElements in Options takes precedence.
+	MaxElements int64
+	// Options are given to every merge before the options of the request.
+	Options []merge.Option
+}
+
+// NewHandler returns a Handler merging with opts before the options of each request.
+func NewHandler(opts ...merge.Option) *Handler {
+	return &Handler{Options: opts}
+}
+
+// document is a base or overlay as received, decoded on demand.
+type document struct {
+	name   string
+	data   []byte
+	format loader.Format
+}
+
+func (d document) decode() (map[string]interface{}, error) {
+	tree, _, err := loader.Decode(d.data, d.format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.name, err)
+	}
+	return tree, nil
+}
+
+// requestError is an error caused by the client.
+type requestError struct {
+	status int
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{"method not allowed"})
+		return
+	}
+	maxBytes := h.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	base, overlays, options, err := readRequest(r)
+	if err == nil {
+		var resp *Response
+		if resp, err = h.merge(base, overlays, options); err == nil {
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+	}
+
+	status := http.StatusUnprocessableEntity
+	var reqErr *requestError
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr), errors.Is(err, merge.ErrLimitExceeded):
+		status = http.StatusRequestEntityTooLarge
+	case errors.As(err, &reqErr):
+		status = reqErr.status
+	}
+	writeJSON(w, status, ErrorResponse{err.Error()})
+}
+
+func (h *Handler) merge(base document, overlays []document, options Options) (*Response, error) {
+	tree, err := base.decode()
+	if err != nil {
+		return nil, &requestError{http.StatusBadRequest, err}
+	}
+	maxElements := h.MaxElements
+	if maxElements == 0 {
+		maxElements = DefaultMaxElements
+	}
+	opts := append(append([]merge.Option{merge.WithMaxElements(maxElements)}, h.Options...), options.mergeOptions()...)
+	for _, overlay := range overlays {
+		src, err := overlay.decode()
+		if err != nil {
+			return nil, &requestError{http.StatusBadRequest, err}
+		}
+		if options.Patch {
+			tree = merge.MergePatch(tree, src).(map[string]interface{})
+			continue
+		}
+		if err = merge.Merge(&tree, src, opts...); err != nil {
+			return nil, fmt.Errorf("%s: %w", overlay.name, err)
+		}
+	}
+
+	resp := &Response{Result: tree}
+	if options.Report {
+		// Merging modifies the base tree, decode it again to compare.
+		before, err := base.decode()
+		if err != nil {
+			return nil, err
+		}
+		resp.Changes = merge.Diff(before, tree)
+	}
+	return resp, nil
+}
+
+func readRequest(r *http.Request) (base document, overlays []document, options Options, err error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "multipart/form-data":
+		return readMultipart(multipart.NewReader(r.Body, params["boundary"]))
+	case mediaType == "application/json" || mediaType == "":
+		var req Request
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return base, nil, options, badRequest("invalid request: %w", err)
+		}
+		if len(req.Base) == 0 {
+			return base, nil, options, badRequest("missing base document")
+		}
+		base = document{"base", req.Base, loader.FormatJSON}
+		for i, overlay := range req.Overlays {
+			overlays = append(overlays, document{fmt.Sprintf("overlay %d", i), overlay, loader.FormatJSON})
+		}
+		return base, overlays, req.Options, nil
+	default:
+		return base, nil, options, &requestError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", mediaType)}
+	}
+}
+
+func readMultipart(mr *multipart.Reader) (base document, overlays []document, options Options, err error) {
+	var hasBase bool
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return base, nil, options, badRequest("invalid multipart body: %w", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return base, nil, options, badRequest("invalid multipart body: %w", err)
+		}
+
+		name := part.FileName()
+		if name == "" {
+			name = part.FormName()
+		}
+		doc := document{name, data, partFormat(part)}
+		switch part.FormName() {
+		case "base":
+			base, hasBase = doc, true
+		case "overlay", "overlays":
+			overlays = append(overlays, doc)
+		case "options":
+			if err = json.Unmarshal(data, &options); err != nil {
+				return base, nil, options, badRequest("invalid options: %w", err)
+			}
+		default:
+			return base, nil, options, badRequest("unknown part %q", part.FormName())
+		}
+	}
+	if !hasBase {
+		return base, nil, options, badRequest("missing base document")
+	}
+	return base, overlays, options, nil
+}
+
+// partFormat guesses the format of a part from its content type, then its file name.
+func partFormat(part *multipart.Part) loader.Format {
+	mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		return loader.FormatJSON
+	case strings.HasSuffix(mediaType, "yaml"):
+		return loader.FormatYAML
+	case part.FileName() != "":
+		return loader.FormatOf(part.FileName())
+	default:
+		return loader.FormatYAML
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudlibraries/merge"
+	"github.com/cloudlibraries/merge/server"
+)
+
+func post(t *testing.T, h http.Handler, contentType string, body []byte) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.Bytes()
+}
+
+func TestHandlerJSON(t *testing.T) {
+	body := `{
+		"base": {"name": "app", "db": {"host": "localhost", "port": 5432}, "tags": ["a"]},
+		"overlays": [{"db": {"host": "db.internal"}, "tags": ["b"]}, {"debug": true}],
+		"options": {"overwrite": true, "appendSlice": true, "report": true}
+	}`
+	code, data := post(t, server.NewHandler(), "application/json", []byte(body))
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, data)
+	}
+	var resp server.Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"name":  "app",
+		"db":    map[string]interface{}{"host": "db.internal", "port": float64(5432)},
+		"tags":  []interface{}{"a", "b"},
+		"debug": true,
+	}
+	if !reflect.DeepEqual(resp.Result, expected) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", resp.Result, expected)
+	}
+	changes := []merge.Change{
+		{Op: merge.ChangeReplace, Path: "db.host", Old: "localhost", New: "db.internal"},
+		{Op: merge.ChangeAdd, Path: "debug", New: true},
+		{Op: merge.ChangeReplace, Path: "tags", Old: []interface{}{"a"}, New: []interface{}{"a", "b"}},
+	}
+	if !reflect.DeepEqual(resp.Changes, changes) {
+		t.Errorf("Test failed:\ngot  :\n%#v\n\nwant :\n%#v\n\n", resp.Changes, changes)
+	}
+}
+
+func TestHandlerMultipart(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	parts := []struct{ field, file, content string }{
+		{"base", "values.yaml", "name: app\ndebug: true\nimage:\n  tag: \"1.0\"\n"},
+		{"overlay", "prod.json", `{"debug": null, "image": {"tag": "2.0"}}`},
+		{"options", "", `{"patch": true}`},
+	}
+	for _, part := range parts {
+		var (
+			w   interface{ Write([]byte) (int, error) }
+			err error
+		)
+		if part.file != "" {
+			w, err = mw.CreateFormFile(part.field, part.file)
+		} else {
+			w, err = mw.CreateFormField(part.field)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(part.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	code, data := post(t, server.NewHandler(), mw.FormDataContentType(), body.Bytes())
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, data)
+	}
+	if got, expected := string(data), `{"result":{"image":{"tag":"2.0"},"name":"app"}}`+"\n"; got != expected {
+		t.Errorf("Test failed:\ngot  :\n%s\n\nwant :\n%s\n\n", got, expected)
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	h := server.NewHandler(merge.WithImmutablePaths("name"))
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"invalid json", "application/json", `{"base":`, http.StatusBadRequest},
+		{"missing base", "application/json", `{"overlays":[{}]}`, http.StatusBadRequest},
+		{"not an object", "application/json", `{"base":[1]}`, http.StatusBadRequest},
+		{"content type", "text/plain", `name: app`, http.StatusUnsupportedMediaType},
+		{"merge error", "application/json", `{"base":{"name":"a"},"overlays":[{"name":"b"}],"options":{"overwrite":true}}`, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, data := post(t, h, test.contentType, []byte(test.body))
+			if code != test.status {
+				t.Errorf("status %d, expected %d: %s", code, test.status, data)
+			}
+			var resp server.ErrorResponse
+			if err := json.Unmarshal(data, &resp); err != nil || resp.Error == "" {
+				t.Errorf("expected an error response, got %s", data)
+			}
+		})
+	}
+
+	small := &server.Handler{MaxBytes: 8}
+	if code, _ := post(t, small, "application/json", []byte(`{"base":{"name":"app"}}`)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, expected %d", code, http.StatusRequestEntityTooLarge)
+	}
+	few := &server.Handler{MaxElements: 4}
+	body := []byte(`{"base":{"a":{"b":{"c":{"d":{"e":0}}}}},"overlays":[{"a":{"b":{"c":{"d":{"e":1}}}}}],"options":{"overwrite":true}}`)
+	if code, data := post(t, few, "application/json", body); code != http.StatusRequestEntityTooLarge || !strings.Contains(string(data), "elements") {
+		t.Errorf("status %d, expected %d for too many elements: %s", code, http.StatusRequestEntityTooLarge, data)
+	}
+	if code, data := post(t, server.NewHandler(), "application/json", body); code != http.StatusOK {
+		t.Errorf("status %d under the default limits: %s", code, data)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(""))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, expected %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
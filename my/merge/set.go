@@ -0,0 +1,204 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrNotSet is returned when a set operation applies to a value that is not a set.
+var ErrNotSet = errors.New("value is not a set")
+
+// SetOp is the operation merging two sets: maps of struct{} or bool, the members of
+// the latter being the keys set to true, and slices of comparable elements.
+type SetOp int
+
+const (
+	// SetUnion keeps the members of dst and adds those of src.
+	SetUnion SetOp = iota + 1
+	// SetIntersect keeps the members of dst also in src.
+	SetIntersect
+	// SetSubtract removes the members of src from dst.
+	SetSubtract
+	// SetSymmetricDifference keeps the members that are in dst or src, but not both.
+	SetSymmetricDifference
+	// SetReplace replaces dst with src.
+	SetReplace
+)
+
+var setOpNames = map[SetOp]string{
+	SetUnion:               "union",
+	SetIntersect:           "intersect",
+	SetSubtract:            "subtract",
+	SetSymmetricDifference: "symmetric-difference",
+	SetReplace:             "replace",
+}
+
+func (op SetOp) String() string {
+	if name, ok := setOpNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("SetOp(%d)", int(op))
+}
+
+// parseSetOp parses the argument of a `merge:"set=..."` tag.
+func parseSetOp(name string) (SetOp, error) {
+	for op, opName := range setOpNames {
+		if strings.EqualFold(name, opName) {
+			return op, nil
+		}
+	}
+	if strings.EqualFold(name, "xor") {
+		return SetSymmetricDifference, nil
+	}
+	return 0, fmt.Errorf("unknown set operation %q", name)
+}
+
+// WithSetOp merges the sets matched by patterns with op, like the `merge:"set=<op>"` tag
+// where op is union, intersect, subtract, symmetric-difference (or xor) or replace.
+// Sets missing from src, or nil, leave dst as it is.
+func WithSetOp(op SetOp, patterns ...string) Option {
+	return func(config *Options) {
+		for _, pattern := range compilePatterns(patterns) {
+			config.setOps = append(config.setOps, pathSetOp{pattern, op})
+		}
+	}
+}
+
+type pathSetOp struct {
+	pattern pathPattern
+	op      SetOp
+}
+
+// setOp returns the set operation for the value at path: the last WithSetOp matching
+// it or else the set directive of its tag.
+func (config *Options) setOp(path fieldPath, tag tagOptions) (SetOp, bool, error) {
+	for i := len(config.setOps) - 1; i >= 0; i-- {
+		if config.setOps[i].pattern.match(path) {
+			return config.setOps[i].op, true, nil
+		}
+	}
+	name, ok := tag["set"]
+	if !ok {
+		return 0, false, nil
+	}
+	op, err := parseSetOp(name)
+	if err != nil {
+		return 0, false, &PathError{path.String(), err}
+	}
+	return op, true, nil
+}
+
+// mergeSet writes with store the result of op on the sets dst and src at path.
+func (config *Options) mergeSet(path fieldPath, dst, src reflect.Value, op SetOp, store func(v reflect.Value) reflect.Value) error {
+	dstSet, srcSet := unwrapInterface(dst), unwrapInterface(src)
+	if isReflectNil(srcSet) {
+		config.trace(path, "keep", dst, src)
+		return nil
+	}
+	if !dstSet.IsValid() || isReflectNil(dstSet) {
+		dstSet = reflect.Zero(srcSet.Type())
+	}
+	if dstSet.Type() != srcSet.Type() {
+		return &PathError{path.String(), fmt.Errorf("%w: %s and %s", ErrDifferentArgumentsTypes, dstSet.Type(), srcSet.Type())}
+	}
+	result, err := applySetOp(dstSet, srcSet, op)
+	if err != nil {
+		return &PathError{path.String(), err}
+	}
+	return config.write(path, ActionSet, dst, result, store)
+}
+
+// applySetOp returns a new set holding the result of op on a and b. Slice members
+// keep their order, those of a first.
+func applySetOp(a, b reflect.Value, op SetOp) (reflect.Value, error) {
+	aMembers, err := setMembers(a)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	bMembers, err := setMembers(b)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	inA, inB := memberIndex(aMembers), memberIndex(bMembers)
+
+	var members []reflect.Value
+	keep := func(from []reflect.Value, in func(m reflect.Value) bool) {
+		for _, m := range from {
+			if in(m) {
+				members = append(members, m)
+			}
+		}
+	}
+	always := func(reflect.Value) bool { return true }
+	switch op {
+	case SetUnion:
+		keep(aMembers, always)
+		keep(bMembers, func(m reflect.Value) bool { return !inA[m.Interface()] })
+	case SetIntersect:
+		keep(aMembers, func(m reflect.Value) bool { return inB[m.Interface()] })
+	case SetSubtract:
+		keep(aMembers, func(m reflect.Value) bool { return !inB[m.Interface()] })
+	case SetSymmetricDifference:
+		keep(aMembers, func(m reflect.Value) bool { return !inB[m.Interface()] })
+		keep(bMembers, func(m reflect.Value) bool { return !inA[m.Interface()] })
+	case SetReplace:
+		keep(bMembers, always)
+	default:
+		return reflect.Value{}, fmt.Errorf("unknown set operation %v", op)
+	}
+
+	typ := a.Type()
+	if typ.Kind() == reflect.Slice {
+		result := reflect.MakeSlice(typ, 0, len(members))
+		return reflect.Append(result, members...), nil
+	}
+	result := reflect.MakeMapWithSize(typ, len(members))
+	present := reflect.New(typ.Elem()).Elem()
+	if typ.Elem().Kind() == reflect.Bool {
+		present.SetBool(true)
+	}
+	for _, m := range members {
+		result.SetMapIndex(m, present)
+	}
+	return result, nil
+}
+
+// setMembers returns the distinct members of the set s.
+func setMembers(s reflect.Value) ([]reflect.Value, error) {
+	typ := s.Type()
+	switch {
+	case typ.Kind() == reflect.Map && (typ.Elem().Kind() == reflect.Bool || typ.Elem().Kind() == reflect.Struct && typ.Elem().NumField() == 0):
+		members := make([]reflect.Value, 0, s.Len())
+		for _, key := range sortedMapKeys(s) {
+			if typ.Elem().Kind() != reflect.Bool || s.MapIndex(key).Bool() {
+				members = append(members, key)
+			}
+		}
+		return members, nil
+	case typ.Kind() == reflect.Slice && typ.Elem().Comparable():
+		members := make([]reflect.Value, 0, s.Len())
+		seen := make(map[interface{}]bool, s.Len())
+		for i := 0; i < s.Len(); i++ {
+			m := s.Index(i)
+			if m.Kind() == reflect.Interface && !m.IsNil() && !m.Elem().Type().Comparable() {
+				return nil, fmt.Errorf("%w: %s holds a %s", ErrNotSet, typ, m.Elem().Type())
+			}
+			if !seen[m.Interface()] {
+				seen[m.Interface()] = true
+				members = append(members, m)
+			}
+		}
+		return members, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotSet, typ)
+}
+
+func memberIndex(members []reflect.Value) map[interface{}]bool {
+	index := make(map[interface{}]bool, len(members))
+	for _, m := range members {
+		index[m.Interface()] = true
+	}
+	return index
+}
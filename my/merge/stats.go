@@ -0,0 +1,147 @@
+package merge
+
+import (
+	"expvar"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts what merges did. The zero value is ready to use and the same Stats can
+// be shared by any number of merges, including concurrent ones.
+type Stats struct {
+	merges      atomic.Int64
+	visited     atomic.Int64
+	written     atomic.Int64
+	overwritten atomic.Int64
+	keysAdded   atomic.Int64
+	keysDeleted atomic.Int64
+	appended    atomic.Int64
+	transformed atomic.Int64
+	nanoseconds atomic.Int64
+}
+
+// StatsSnapshot holds the counters of a Stats at one point in time.
+type StatsSnapshot struct {
+	// Merges is the number of calls to Merge, Map and their variants.
+	Merges int64 `json:"merges"`
+	// Visited is the number of values merges walked through.
+	Visited int64 `json:"visited"`
+	// Written is the number of values written to dst, Overwritten the number of them
+	// that replaced a non-empty value.
+	Written     int64 `json:"written"`
+	Overwritten int64 `json:"overwritten"`
+	// KeysAdded counts the map keys, and keyed slice elements, merges added to dst.
+	// KeysDeleted counts the map keys merges removed or left holding nil: keys written
+	// over with a nil value, from src or from a hook, and keys added for a filtered
+	// subtree then removed because nothing was merged below them.
+	KeysAdded   int64 `json:"keysAdded"`
+	KeysDeleted int64 `json:"keysDeleted"`
+	// Appended is the number of slices appended with WithAppendSlice.
+	Appended int64 `json:"appended"`
+	// Transformed is the number of values merged by a transformer.
+	Transformed int64 `json:"transformed"`
+	// Duration is the wall time spent in merges.
+	Duration time.Duration `json:"duration"`
+}
+
+// WithStats will make merge count what it does into stats.
+func WithStats(stats *Stats) Option {
+	return func(config *Options) {
+		config.stats = stats
+	}
+}
+
+// Snapshot returns the current value of the counters.
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Merges:      s.merges.Load(),
+		Visited:     s.visited.Load(),
+		Written:     s.written.Load(),
+		Overwritten: s.overwritten.Load(),
+		KeysAdded:   s.keysAdded.Load(),
+		KeysDeleted: s.keysDeleted.Load(),
+		Appended:    s.appended.Load(),
+		Transformed: s.transformed.Load(),
+		Duration:    time.Duration(s.nanoseconds.Load()),
+	}
+}
+
+// StatsExporter receives counters by name, to bridge Stats to a metrics system.
+type StatsExporter interface {
+	ExportCounter(name string, value float64)
+}
+
+// Export passes every counter to e, the duration in seconds: merges, visited, written,
+// overwritten, keys_added, keys_deleted, appended, transformed and duration_seconds.
+func (s *Stats) Export(e StatsExporter) {
+	snapshot := s.Snapshot()
+	e.ExportCounter("merges", float64(snapshot.Merges))
+	e.ExportCounter("visited", float64(snapshot.Visited))
+	e.ExportCounter("written", float64(snapshot.Written))
+	e.ExportCounter("overwritten", float64(snapshot.Overwritten))
+	e.ExportCounter("keys_added", float64(snapshot.KeysAdded))
+	e.ExportCounter("keys_deleted", float64(snapshot.KeysDeleted))
+	e.ExportCounter("appended", float64(snapshot.Appended))
+	e.ExportCounter("transformed", float64(snapshot.Transformed))
+	e.ExportCounter("duration_seconds", snapshot.Duration.Seconds())
+}
+
+// ExpvarStats returns an expvar.Var reading the counters of s, e.g.
+//
+//	expvar.Publish("merge", merge.ExpvarStats(stats))
+func ExpvarStats(s *Stats) expvar.Var {
+	return expvar.Func(func() interface{} {
+		return s.Snapshot()
+	})
+}
+
+// The counting methods do nothing on a nil Stats, so that merges without WithStats
+// only pay for a nil check.
+
+func (s *Stats) done(start time.Time) {
+	if s != nil {
+		s.merges.Add(1)
+		s.nanoseconds.Add(int64(time.Since(start)))
+	}
+}
+
+func (s *Stats) visit() {
+	if s != nil {
+		s.visited.Add(1)
+	}
+}
+
+func (s *Stats) transform() {
+	if s != nil {
+		s.transformed.Add(1)
+	}
+}
+
+// wrote counts a value written over dst, invalid for a new map key or slice element.
+func (s *Stats) wrote(action HookAction, dst reflect.Value) {
+	if s == nil {
+		return
+	}
+	s.written.Add(1)
+	switch {
+	case action == ActionAppend:
+		s.appended.Add(1)
+	case !dst.IsValid():
+		s.keysAdded.Add(1)
+	case !isEmptyValue(dst):
+		s.overwritten.Add(1)
+	}
+}
+
+func (s *Stats) keyCreated() {
+	if s != nil {
+		s.keysAdded.Add(1)
+	}
+}
+
+func (s *Stats) keyDeleted() {
+	if s != nil {
+		s.keysDeleted.Add(1)
+	}
+}
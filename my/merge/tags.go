@@ -0,0 +1,66 @@
+package merge
+
+import (
+	"reflect"
+	"strings"
+)
+
+// tagName is the struct tag holding per-field merge directives,
+// e.g. `merge:"immutable"` or `merge:"required,writeonce"`.
+const tagName = "merge"
+
+// tagOptions maps every directive of a merge tag to its argument, if any.
+type tagOptions map[string]string
+
+// parseTag splits the merge tag of field into its directives. An argument can be
+// single quoted to hold commas or surrounding spaces, two single quotes standing for
+// one inside it: `merge:"leaf=template,template='{{.dst}},{{.src}}'"`.
+func parseTag(field reflect.StructField) tagOptions {
+	tag, ok := field.Tag.Lookup(tagName)
+	if !ok || tag == "" {
+		return nil
+	}
+	options := make(tagOptions)
+	for tag != "" {
+		var directive string
+		directive, tag = nextDirective(tag)
+		name, arg, _ := strings.Cut(directive, "=")
+		if name = strings.TrimSpace(name); name != "" {
+			options[name] = unquoteArg(arg)
+		}
+	}
+	return options
+}
+
+// other/merge/leaf.go copies nextDirective and unquoteArg: change them together.
+
+// nextDirective returns the first directive of tag, up to the first comma that is
+// not quoted, and what follows that comma.
+func nextDirective(tag string) (string, string) {
+	quoted := false
+	for i := 0; i < len(tag); i++ {
+		switch tag[i] {
+		case '\'':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return tag[:i], tag[i+1:]
+			}
+		}
+	}
+	return tag, ""
+}
+
+// unquoteArg trims arg and, when it is single quoted, strips its quotes.
+func unquoteArg(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && arg[0] == '\'' && arg[len(arg)-1] == '\'' {
+		return strings.ReplaceAll(arg[1:len(arg)-1], "''", "'")
+	}
+	return arg
+}
+
+func (t tagOptions) has(name string) bool {
+	_, ok := t[name]
+	return ok
+}
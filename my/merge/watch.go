@@ -0,0 +1,194 @@
+package merge
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Layer is one file of a layered configuration watched by Watch.
+type Layer struct {
+	Path string
+	// Decode turns the content of the file into a tree merged into the
+	// configuration, see the loader package for JSON and YAML.
+	Decode func(data []byte) (map[string]interface{}, error)
+	// Options are given to Map, or to Merge when the configuration is not a struct.
+	Options []Option
+	// Optional layers may be missing, they are then skipped.
+	Optional bool
+}
+
+// WatchEvent is what Watch reports after reloading the configuration.
+type WatchEvent[T any] struct {
+	// Config is the configuration in use: the new one, or the previous one when Err is set.
+	Config *T
+	// Changes turn the previous configuration into the new one.
+	Changes []Change
+	Err     error
+}
+
+// WatchOption customizes Watch.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	interval time.Duration
+}
+
+// WithPollInterval changes how often Watch checks the layers, every second by default.
+func WithPollInterval(interval time.Duration) WatchOption {
+	return func(config *watchOptions) {
+		config.interval = interval
+	}
+}
+
+// Validator is implemented by configurations that check themselves after every merge.
+type Validator interface {
+	Validate() error
+}
+
+// Watch merges layers in order into a new T, stores it in cfg and keeps doing so in
+// the background, until ctx is done, every time the modification time and then the
+// content of a layer changes. Every configuration is checked with CheckRequired and,
+// when T implements Validator, with Validate before it is stored; onChange, which may
+// be nil, receives every new configuration with the changes from the previous one and
+// every failed reload, after which the previous configuration stays in use.
+// Watch returns once the first configuration is stored, or the error preventing it.
+func Watch[T any](ctx context.Context, layers []Layer, cfg *atomic.Pointer[T], onChange func(WatchEvent[T]), opts ...WatchOption) error {
+	config := &watchOptions{interval: time.Second}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.interval <= 0 {
+		return fmt.Errorf("merge: invalid poll interval %v", config.interval)
+	}
+
+	w := &watcher[T]{layers: layers, states: make([]layerState, len(layers))}
+	if _, err := w.poll(); err != nil {
+		return err
+	}
+	value, err := w.load()
+	if err != nil {
+		return err
+	}
+	cfg.Store(value)
+
+	go func() {
+		ticker := time.NewTicker(config.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			changed, err := w.poll()
+			if !changed && err == nil {
+				continue
+			}
+			if err == nil {
+				var next *T
+				if next, err = w.load(); err == nil {
+					event := WatchEvent[T]{Config: next, Changes: Diff(cfg.Load(), next)}
+					cfg.Store(next)
+					if onChange != nil && len(event.Changes) > 0 {
+						onChange(event)
+					}
+					continue
+				}
+			}
+			if onChange != nil {
+				onChange(WatchEvent[T]{Config: cfg.Load(), Err: err})
+			}
+		}
+	}()
+	return nil
+}
+
+type layerState struct {
+	modTime time.Time
+	size    int64
+	missing bool
+	hash    [sha256.Size]byte
+	data    []byte
+}
+
+type watcher[T any] struct {
+	layers []Layer
+	states []layerState
+}
+
+// poll reads the layers whose modification time changed and reports whether the
+// content of any of them did. The states are only updated once every layer is read,
+// so a failed poll is retried in full.
+func (w *watcher[T]) poll() (changed bool, err error) {
+	states := make([]layerState, len(w.layers))
+	for i, layer := range w.layers {
+		state := w.states[i]
+		info, err := os.Stat(layer.Path)
+		if errors.Is(err, os.ErrNotExist) && layer.Optional {
+			if !state.missing {
+				changed = true
+			}
+			states[i] = layerState{missing: true}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !state.missing && info.ModTime().Equal(state.modTime) && info.Size() == state.size && state.data != nil {
+			states[i] = state
+			continue
+		}
+		data, err := os.ReadFile(layer.Path)
+		if err != nil {
+			return false, err
+		}
+		hash := sha256.Sum256(data)
+		if state.missing || state.data == nil || hash != state.hash {
+			changed = true
+		}
+		states[i] = layerState{modTime: info.ModTime(), size: info.Size(), hash: hash, data: data}
+	}
+	w.states = states
+	return changed, nil
+}
+
+// load merges the layers last read by poll into a new T.
+func (w *watcher[T]) load() (*T, error) {
+	value := new(T)
+	isStruct := reflect.TypeOf(value).Elem().Kind() == reflect.Struct
+	for i, layer := range w.layers {
+		state := w.states[i]
+		if state.missing {
+			continue
+		}
+		tree, err := layer.Decode(state.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", layer.Path, err)
+		}
+		if isStruct {
+			err = Map(value, tree, layer.Options...)
+		} else {
+			err = Merge(value, tree, layer.Options...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", layer.Path, err)
+		}
+	}
+	if isStruct {
+		if err := CheckRequired(value); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := interface{}(value).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
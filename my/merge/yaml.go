@@ -0,0 +1,285 @@
+package merge
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// MergeYAMLNodes merges the src YAML tree into dst in place, with the semantics Merge has
+// for maps: keys missing in dst are appended in src order, scalars are only replaced when
+// empty in dst unless WithOverwrite is given, and sequences are replaced, appended with
+// WithAppendSlice or matched element by element with WithMergeKeys. The comments, key
+// order, styles and anchors of dst are kept, so re-encoding dst gives a readable diff.
+// Aliases in src are expanded into the nodes they copy.
+func MergeYAMLNodes(dst, src *yaml.Node, opts ...Option) error {
+	if dst == nil || src == nil {
+		return ErrNilArguments
+	}
+	config := &Options{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	srcRoot := yamlRoot(src)
+	if config.sourceNode == nil {
+		config.sourceNode = srcRoot
+	}
+	if srcRoot == nil {
+		return nil
+	}
+	if dst.Kind == yaml.DocumentNode {
+		if len(dst.Content) == 0 {
+			if config.filter(nil) == filterAllow {
+				dst.Content = []*yaml.Node{copyYAMLNode(srcRoot)}
+				config.written(nil)
+			}
+			return nil
+		}
+		dst = dst.Content[0]
+	}
+	mergeYAMLNode(dst, srcRoot, nil, config)
+	return nil
+}
+
+func mergeYAMLNode(dst, src *yaml.Node, path fieldPath, config *Options) {
+	mode := config.filter(path)
+	if mode == filterSkip {
+		return
+	}
+	src = resolveYAMLAlias(src)
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		mergeYAMLMapping(dst, src, path, config)
+		return
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode:
+		mergeYAMLSequence(dst, src, path, mode, config)
+		return
+	}
+	if mode != filterAllow {
+		return
+	}
+	if isEmptyYAMLNode(src) && !config.overwriteWithEmptyValue {
+		return
+	}
+	if !isEmptyYAMLNode(dst) && !config.overwrite {
+		return
+	}
+	replaceYAMLNode(dst, src)
+	config.written(path)
+}
+
+func mergeYAMLMapping(dst, src *yaml.Node, path fieldPath, config *Options) {
+	for _, pair := range yamlMappingPairs(src) {
+		key, value := pair[0], pair[1]
+		keyPath := path.child(key.Value)
+		keyMode := config.filter(keyPath)
+		if keyMode == filterSkip {
+			continue
+		}
+		if i := yamlMappingIndex(dst, key.Value); i >= 0 {
+			mergeYAMLNode(dst.Content[i+1], value, keyPath, config)
+			continue
+		}
+		if keyMode == filterAllow {
+			dst.Content = append(dst.Content, copyYAMLNode(key), copyYAMLNode(value))
+			config.written(keyPath)
+			continue
+		}
+		// Only part of the missing subtree may be written: start from an empty one.
+		if value = resolveYAMLAlias(value); value.Kind == yaml.MappingNode {
+			node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			mergeYAMLMapping(node, value, keyPath, config)
+			if len(node.Content) > 0 {
+				dst.Content = append(dst.Content, copyYAMLNode(key), node)
+			}
+		}
+	}
+}
+
+func mergeYAMLSequence(dst, src *yaml.Node, path fieldPath, mode filterMode, config *Options) {
+	if mode == filterDescend {
+		for i := 0; i < len(dst.Content) && i < len(src.Content); i++ {
+			mergeYAMLNode(dst.Content[i], src.Content[i], path.index(i), config)
+		}
+		return
+	}
+	if len(src.Content) == 0 {
+		if len(dst.Content) > 0 && (config.overwriteWithEmptyValue || config.overwriteSliceWithEmptyValue) {
+			dst.Content = nil
+			config.written(path)
+		}
+		return
+	}
+	if len(dst.Content) > 0 && mergeKeyedYAMLSequence(dst, src, path, config) {
+		return
+	}
+	switch {
+	case config.appendSlice:
+		for _, item := range src.Content {
+			dst.Content = append(dst.Content, copyYAMLNode(item))
+			config.written(path.index(len(dst.Content) - 1))
+		}
+	case config.overwrite || len(dst.Content) == 0:
+		replaceYAMLNode(dst, src)
+		config.written(path)
+	}
+}
+
+// mergeKeyedYAMLSequence is mergeKeyedSlice for sequences of mappings.
+func mergeKeyedYAMLSequence(dst, src *yaml.Node, path fieldPath, config *Options) bool {
+	for _, name := range config.mergeKeys {
+		dstKeys, dstOk := yamlSequenceKeys(dst, name)
+		srcKeys, srcOk := yamlSequenceKeys(src, name)
+		if !dstOk || !srcOk {
+			continue
+		}
+		index := make(map[string]int, len(dstKeys))
+		for i, key := range dstKeys {
+			if _, dup := index[key]; !dup {
+				index[key] = i
+			}
+		}
+		for j, key := range srcKeys {
+			if i, found := index[key]; found {
+				mergeYAMLNode(dst.Content[i], src.Content[j], path.index(i), config)
+				continue
+			}
+			dst.Content = append(dst.Content, copyYAMLNode(src.Content[j]))
+			index[key] = len(dst.Content) - 1
+			config.written(path.index(len(dst.Content) - 1))
+		}
+		return true
+	}
+	return false
+}
+
+// yamlSequenceKeys returns the value of the name key of every item of seq, if they all have one.
+func yamlSequenceKeys(seq *yaml.Node, name string) ([]string, bool) {
+	keys := make([]string, len(seq.Content))
+	for i, item := range seq.Content {
+		item = resolveYAMLAlias(item)
+		if item.Kind != yaml.MappingNode {
+			return nil, false
+		}
+		j := yamlMappingIndex(item, name)
+		if j < 0 {
+			return nil, false
+		}
+		value := resolveYAMLAlias(item.Content[j+1])
+		if value.Kind != yaml.ScalarNode || isEmptyYAMLNode(value) {
+			return nil, false
+		}
+		keys[i] = value.Value
+	}
+	return keys, true
+}
+
+// yamlMappingPairs returns the key/value pairs of a mapping, with the entries of "<<"
+// merge keys that are not overridden by the mapping itself.
+func yamlMappingPairs(node *yaml.Node) [][2]*yaml.Node {
+	var pairs, merged [][2]*yaml.Node
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Tag != "!!merge" {
+			pairs = append(pairs, [2]*yaml.Node{key, value})
+			seen[key.Value] = true
+			continue
+		}
+		sources := []*yaml.Node{value}
+		if value = resolveYAMLAlias(value); value.Kind == yaml.SequenceNode {
+			sources = value.Content
+		}
+		for _, source := range sources {
+			if source = resolveYAMLAlias(source); source.Kind == yaml.MappingNode {
+				merged = append(merged, yamlMappingPairs(source)...)
+			}
+		}
+	}
+	for _, pair := range merged {
+		if !seen[pair[0].Value] {
+			pairs = append(pairs, pair)
+			seen[pair[0].Value] = true
+		}
+	}
+	return pairs
+}
+
+func yamlMappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Tag != "!!merge" && node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func yamlRoot(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+	return resolveYAMLAlias(node)
+}
+
+func resolveYAMLAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func isEmptyYAMLNode(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Tag == "!!null" || node.Value == ""
+	case yaml.MappingNode, yaml.SequenceNode, yaml.DocumentNode:
+		return len(node.Content) == 0
+	}
+	return false
+}
+
+// replaceYAMLNode replaces dst with a copy of src, keeping the comments and anchor of dst.
+func replaceYAMLNode(dst, src *yaml.Node) {
+	node := copyYAMLNode(src)
+	node.Anchor = dst.Anchor
+	if dst.HeadComment != "" || dst.LineComment != "" || dst.FootComment != "" {
+		node.HeadComment, node.LineComment, node.FootComment = dst.HeadComment, dst.LineComment, dst.FootComment
+	}
+	node.Line, node.Column = dst.Line, dst.Column
+	*dst = *node
+}
+
+// copyYAMLNode deep copies node, expanding aliases and dropping anchors so the copy
+// can be inserted anywhere in another document.
+func copyYAMLNode(node *yaml.Node) *yaml.Node {
+	target := resolveYAMLAlias(node)
+	ret := *target
+	ret.Anchor = ""
+	if target != node {
+		ret.HeadComment, ret.LineComment, ret.FootComment = node.HeadComment, node.LineComment, node.FootComment
+	}
+	if len(target.Content) > 0 {
+		ret.Content = make([]*yaml.Node, len(target.Content))
+		for i, child := range target.Content {
+			ret.Content[i] = copyYAMLNode(child)
+		}
+	}
+	if ret.Kind == yaml.MappingNode && hasYAMLMergeKey(&ret) {
+		pairs := yamlMappingPairs(&ret)
+		ret.Content = make([]*yaml.Node, 0, len(pairs)*2)
+		for _, pair := range pairs {
+			ret.Content = append(ret.Content, pair[0], pair[1])
+		}
+	}
+	return &ret
+}
+
+func hasYAMLMergeKey(node *yaml.Node) bool {
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Tag == "!!merge" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,11 @@
 package merge
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
 
 var (
 	ErrKindNotSupported = errors.New("kind not supported")
@@ -11,4 +16,34 @@ var (
 	ErrUnknownResolver  = errors.New("unknown resolver")
 	ErrNotSettable      = errors.New("must be settable")
 	ErrInvalidStrategy  = errors.New("invalid strategy")
+	ErrNotString        = errors.New("value is not a string")
 )
+
+// PathError records an error and the path of the value that caused it, made
+// of field names, map keys and indices separated by dots.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+func formatPath(path []any) string {
+	segments := make([]string, len(path))
+	for i, segment := range path {
+		if key, ok := segment.(reflect.Value); ok && key.IsValid() && key.CanInterface() {
+			segment = key.Interface()
+		}
+		segments[i] = fmt.Sprint(segment)
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,259 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// LeafStrategy computes the merged value of two leaves of the same type, neither of
+// them zero. Leaves where dst or src is zero are merged as usual.
+type LeafStrategy func(dst, src reflect.Value) (reflect.Value, error)
+
+// stringStrategy makes a LeafStrategy on values of kind string from fn.
+func stringStrategy(fn func(dst, src string) (string, error)) LeafStrategy {
+	return func(dst, src reflect.Value) (reflect.Value, error) {
+		if dst.Kind() != reflect.String {
+			return reflect.Value{}, fmt.Errorf("%w: %s", ErrNotString, dst.Type())
+		}
+		result, err := fn(dst.String(), src.String())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ret := makeZeroValue(dst)
+		ret.SetString(result)
+		return ret, nil
+	}
+}
+
+// Concat joins dst and src with sep.
+func Concat(sep string) LeafStrategy {
+	return stringStrategy(func(dst, src string) (string, error) {
+		return dst + sep + src, nil
+	})
+}
+
+// AppendIfMissing appends src to dst, a list of items separated by sep, unless it
+// is one of them already. Without a separator, src is appended unless dst contains it.
+func AppendIfMissing(sep string) LeafStrategy {
+	return stringStrategy(func(dst, src string) (string, error) {
+		if containsItem(dst, src, sep) {
+			return dst, nil
+		}
+		return dst + sep + src, nil
+	})
+}
+
+// PrependIfMissing prepends src to dst, see AppendIfMissing.
+func PrependIfMissing(sep string) LeafStrategy {
+	return stringStrategy(func(dst, src string) (string, error) {
+		if containsItem(dst, src, sep) {
+			return dst, nil
+		}
+		return src + sep + dst, nil
+	})
+}
+
+// containsItem is a copy of my/merge/leafstring.go, its source: change them together.
+func containsItem(list, item, sep string) bool {
+	if sep == "" {
+		return strings.Contains(list, item)
+	}
+	for _, existing := range strings.Split(list, sep) {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
+
+// Template executes the text/template text with the dst and src values as .dst
+// and .src, e.g. "{{.dst}},{{.src}}". A parse error is returned by Merge.
+func Template(text string) LeafStrategy {
+	tmpl, err := template.New("merge").Option("missingkey=error").Parse(text)
+	return stringStrategy(func(dst, src string) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, map[string]string{"dst": dst, "src": src}); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	})
+}
+
+// WithLeafStrategy merges the leaves at paths with strategy. Paths are made of field
+// names, map keys and indices separated by dots, e.g. "Env.PATH".
+func WithLeafStrategy(strategy LeafStrategy, paths ...string) Option {
+	return func(config *Options) {
+		if config.leafPaths == nil {
+			config.leafPaths = make(map[string]LeafStrategy)
+		}
+		for _, path := range paths {
+			config.leafPaths[path] = strategy
+		}
+	}
+}
+
+// tagStrategies caches the strategies of struct tags, by tag.
+var tagStrategies sync.Map
+
+// tagLeafStrategy returns the strategy of the `merge:"leaf=<name>"` tag of field, if
+// any. The string strategies concat, append and prepend take their separator from a
+// sep directive, a comma by default: `merge:"leaf=prepend,sep=:"`. template takes its
+// text from a template directive: `merge:"leaf=template,template={{.dst}}-{{.src}}"`.
+// Either is single quoted to hold commas or spaces, two single quotes standing for
+// one: `merge:"leaf=concat,sep=' '"`. Tags without a leaf directive are ignored.
+func tagLeafStrategy(field reflect.StructField) (LeafStrategy, error) {
+	tag, ok := field.Tag.Lookup("merge")
+	if !ok {
+		return nil, nil
+	}
+	if strategy, ok := tagStrategies.Load(tag); ok {
+		return strategy.(LeafStrategy), nil
+	}
+	options := parseTag(tag)
+	name, ok := options["leaf"]
+	if !ok {
+		return nil, nil
+	}
+	sep, ok := options["sep"]
+	if !ok {
+		sep = ","
+	}
+	var strategy LeafStrategy
+	switch name {
+	case "concat":
+		strategy = Concat(sep)
+	case "append":
+		strategy = AppendIfMissing(sep)
+	case "prepend":
+		strategy = PrependIfMissing(sep)
+	case "template":
+		strategy = Template(options["template"])
+	default:
+		return nil, fmt.Errorf("%w: leaf=%s", ErrInvalidStrategy, name)
+	}
+	tagStrategies.Store(tag, strategy)
+	return strategy, nil
+}
+
+// parseTag maps every comma separated directive of tag to its argument, if any.
+// Commas between single quotes do not separate directives.
+func parseTag(tag string) map[string]string {
+	options := make(map[string]string)
+	for tag != "" {
+		var directive string
+		directive, tag = nextDirective(tag)
+		name, arg, _ := strings.Cut(directive, "=")
+		if name = strings.TrimSpace(name); name != "" {
+			options[name] = unquoteArg(arg)
+		}
+	}
+	return options
+}
+
+// nextDirective and unquoteArg are copies of my/merge/tags.go, their source: change
+// them together.
+
+// nextDirective returns the first directive of tag, up to the first comma that is
+// not quoted, and what follows that comma.
+func nextDirective(tag string) (string, string) {
+	quoted := false
+	for i := 0; i < len(tag); i++ {
+		switch tag[i] {
+		case '\'':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return tag[:i], tag[i+1:]
+			}
+		}
+	}
+	return tag, ""
+}
+
+// unquoteArg trims arg and, when it is single quoted, strips its quotes.
+func unquoteArg(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && arg[0] == '\'' && arg[len(arg)-1] == '\'' {
+		return strings.ReplaceAll(arg[1:len(arg)-1], "''", "'")
+	}
+	return arg
+}
+
+// leafStrategy returns the WithLeafStrategy strategy of the current path.
+func (m *merger) leafStrategy() (LeafStrategy, bool) {
+	if len(m.leafPaths) == 0 {
+		return nil, false
+	}
+	strategy, ok := m.leafPaths[formatPath(m.path)]
+	return strategy, ok
+}
+
+// mergeLeaf merges dst and src with strategy at segment of the current path. It
+// reports false when one of them is zero, or the conditions do not hold.
+func (m *merger) mergeLeaf(segment any, strategy LeafStrategy, dst, src reflect.Value) (
+	reflect.Value, bool, error) {
+	if !dst.IsValid() || !src.IsValid() || dst.IsZero() || src.IsZero() ||
+		dst.Type() != src.Type() || !m.conditions.Check(dst, src) {
+		return reflect.Value{}, false, nil
+	}
+	ret, err := strategy(dst, src)
+	if err != nil {
+		path := m.path
+		if segment != nil {
+			path = append(path[:len(path):len(path)], segment)
+		}
+		return reflect.Value{}, true, &PathError{Path: formatPath(path), Err: err}
+	}
+	return ret, true, nil
+}
+
+// elem returns the value of the element at segment of the values being merged under
+// the ReplaceElem strategies: its WithLeafStrategy merge if any, src when the
+// conditions hold and dst otherwise.
+func (m *merger) elem(segment any, dst, src reflect.Value) (reflect.Value, error) {
+	if len(m.leafPaths) > 0 {
+		m.path = append(m.path, segment)
+		strategy, ok := m.leafStrategy()
+		var ret reflect.Value
+		var err error
+		if ok {
+			ret, ok, err = m.mergeLeaf(nil, strategy, dst, src)
+			if ok && err == nil {
+				m.trace(src, "leaf")
+			}
+		}
+		m.path = m.path[:len(m.path)-1]
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if ok {
+			return ret, nil
+		}
+	}
+	if m.check(segment, dst, src) {
+		return src, nil
+	}
+	return dst, nil
+}
+
+// mergeTaggedField merges the field at index of dst and src, of type typ, with the
+// strategy of its tag. It reports false when the field has none or mergeLeaf does.
+func (m *merger) mergeTaggedField(typ reflect.Type, index int, dst, src reflect.Value) (
+	reflect.Value, bool, error) {
+	field := typ.Field(index)
+	strategy, err := tagLeafStrategy(field)
+	if err != nil {
+		path := append(m.path[:len(m.path):len(m.path)], field.Name)
+		return reflect.Value{}, true, &PathError{Path: formatPath(path), Err: err}
+	}
+	if strategy == nil {
+		return reflect.Value{}, false, nil
+	}
+	return m.mergeLeaf(field.Name, strategy, dst, src)
+}
@@ -0,0 +1,71 @@
+package merge
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+)
+
+// WithLogger makes Merge log each decision at debug level: the path and kind of the
+// value, the strategy chosen for it, the result of the conditions and the action taken.
+func WithLogger(logger *slog.Logger) Option {
+	return func(config *Options) {
+		config.logger = logger
+	}
+}
+
+func (m *merger) tracing() bool {
+	return m.logger != nil && m.logger.Enabled(m.context(), slog.LevelDebug)
+}
+
+func (m *merger) context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+func (m *merger) trace(src reflect.Value, action string, attrs ...slog.Attr) {
+	if !m.tracing() {
+		return
+	}
+	attrs = append([]slog.Attr{
+		slog.String("path", formatPath(m.path)),
+		slog.String("kind", src.Kind().String()),
+		slog.String("action", action),
+	}, attrs...)
+	m.logger.LogAttrs(m.context(), slog.LevelDebug, "merge", attrs...)
+}
+
+// check checks the conditions for the element at segment of the values being merged.
+func (m *merger) check(segment any, dst, src reflect.Value) bool {
+	ok := m.conditions.Check(dst, src)
+	if m.tracing() {
+		action := "keep"
+		if ok {
+			action = "replace"
+		}
+		m.path = append(m.path, segment)
+		m.trace(src, action, slog.Bool("conditions", ok))
+		m.path = m.path[:len(m.path)-1]
+	}
+	return ok
+}
+
+// strategy returns the strategy used to merge values of kind.
+func (m *merger) strategy(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Array:
+		return m.arrayStrategy.String()
+	case reflect.Struct:
+		return m.structStrategy.String()
+	case reflect.Slice:
+		return m.sliceStrategy.String()
+	case reflect.Chan:
+		return m.chanStrategy.String()
+	case reflect.Map:
+		return m.mapStrategy.String()
+	default:
+		return "Default"
+	}
+}
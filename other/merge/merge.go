@@ -1,18 +1,28 @@
 package merge
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"reflect"
 )
 
 func Merge(dst, src any, opts ...Option) (any, error) {
+	return MergeContext(context.Background(), dst, src, opts...)
+}
+
+// MergeContext is Merge, stopping when ctx is done. The error is then a *PathError
+// wrapping ctx.Err() with the path reached. As Merge returns a new value, dst is
+// left untouched either way.
+func MergeContext(ctx context.Context, dst, src any, opts ...Option) (any, error) {
 	options := newOptions(opts)
 	merger := newMerger(options)
+	if ctx != context.Background() {
+		// The context of Merge is never done, leave the merge unchecked.
+		merger.ctx = ctx
+	}
 
-	vDst := reflect.ValueOf(dst)
-	vSrc := reflect.ValueOf(src)
-
-	vRet, err := merger.merge(vDst, vSrc, merger.defaultResolver)
+	vRet, err := merger.merge(reflect.ValueOf(dst), reflect.ValueOf(src), merger.defaultResolver)
 	if err != nil {
 		return nil, err
 	}
@@ -44,8 +54,16 @@ func SafeMerge(dst, src any, opts ...Option) (
 
 type merger struct {
 	*Options
+
+	// ctx, when set, is checked every contextCheckInterval values; path is
+	// then kept up to date to report where the merge stopped.
+	ctx   context.Context
+	steps int
+	path  []any
 }
 
+const contextCheckInterval = 64
+
 func newMerger(options *Options) *merger {
 	return &merger{
 		Options: options,
@@ -54,14 +72,31 @@ func newMerger(options *Options) *merger {
 
 func (m *merger) merge(dst, src reflect.Value, resolver Resolver) (
 	reflect.Value, error) {
+	if err := m.checkContext(); err != nil {
+		return reflect.Value{}, err
+	}
+
 	dst, src, depth, err := resolve(dst, src, resolver)
 	if err != nil {
 		return reflect.Value{}, err
 	}
 
+	if strategy, ok := m.leafStrategy(); ok {
+		ret, ok, err := m.mergeLeaf(nil, strategy, dst, src)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if ok {
+			m.trace(src, "leaf")
+			return makeDeepPointer(ret, depth), nil
+		}
+	}
+
 	if !m.conditions.Check(dst, src) {
+		m.trace(src, "keep", slog.Bool("conditions", false))
 		return dst, nil
 	}
+	m.trace(src, "merge", slog.Bool("conditions", true), slog.String("strategy", m.strategy(dst.Kind())))
 
 	var ret reflect.Value
 	switch dst.Kind() {
@@ -86,6 +121,31 @@ func (m *merger) merge(dst, src reflect.Value, resolver Resolver) (
 	return makeDeepPointer(ret, depth), nil
 }
 
+// mergeAt merges the element at segment of the values being merged.
+func (m *merger) mergeAt(segment any, dst, src reflect.Value, resolver Resolver) (
+	reflect.Value, error) {
+	if m.ctx == nil && m.logger == nil && len(m.leafPaths) == 0 {
+		return m.merge(dst, src, resolver)
+	}
+	m.path = append(m.path, segment)
+	defer func() { m.path = m.path[:len(m.path)-1] }()
+	return m.merge(dst, src, resolver)
+}
+
+func (m *merger) checkContext() error {
+	if m.ctx == nil {
+		return nil
+	}
+	m.steps++
+	if m.steps%contextCheckInterval != 1 {
+		return nil
+	}
+	if err := m.ctx.Err(); err != nil {
+		return &PathError{Path: formatPath(m.path), Err: err}
+	}
+	return nil
+}
+
 func (m *merger) mergeDefault(dst, src reflect.Value) (reflect.Value, error) {
 	return makeCopiedValue(src), nil
 }
@@ -121,11 +181,11 @@ func (m *merger) mergeArray(dst, src reflect.Value) (reflect.Value, error) {
 				return reflect.Value{}, err
 			}
 
-			if m.conditions.Check(dstElem, srcElem) {
-				ret.Index(index).Set(makeDeepPointer(srcElem, depth))
-			} else {
-				ret.Index(index).Set(makeDeepPointer(dstElem, depth))
+			v, err := m.elem(index, dstElem, srcElem)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			ret.Index(index).Set(makeDeepPointer(v, depth))
 		}
 
 	case ArrayStrategyReplaceDeep:
@@ -141,7 +201,7 @@ func (m *merger) mergeArray(dst, src reflect.Value) (reflect.Value, error) {
 			if index < src.Len() {
 				srcElem = src.Index(index)
 			}
-			v, err := m.merge(dstElem, srcElem, m.arrayResolver)
+			v, err := m.mergeAt(index, dstElem, srcElem, m.arrayResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -174,11 +234,17 @@ func (m *merger) mergeStruct(dst, src reflect.Value) (reflect.Value, error) {
 		for index, length := 0, dst.NumField(); index < length; index++ {
 			dstValue := getValueFromField(dst.Field(index))
 			srcValue := getValueFromField(src.Field(index))
-			if m.conditions.Check(dstValue, srcValue) {
-				setValueToField(ret.Field(index), srcValue)
-			} else {
-				setValueToField(ret.Field(index), dstValue)
+			if v, ok, err := m.mergeTaggedField(dst.Type(), index, dstValue, srcValue); err != nil {
+				return reflect.Value{}, err
+			} else if ok {
+				setValueToField(ret.Field(index), v)
+				continue
+			}
+			v, err := m.elem(dst.Type().Field(index).Name, dstValue, srcValue)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			setValueToField(ret.Field(index), v)
 		}
 
 	case StructStrategyReplaceElemExported:
@@ -190,11 +256,17 @@ func (m *merger) mergeStruct(dst, src reflect.Value) (reflect.Value, error) {
 			if isExported(dst, index) {
 				dstValue := getValueFromField(dst.Field(index))
 				srcValue := getValueFromField(src.Field(index))
-				if m.conditions.Check(dstValue, srcValue) {
-					setValueToField(ret.Field(index), srcValue)
-				} else {
-					setValueToField(ret.Field(index), dstValue)
+				if v, ok, err := m.mergeTaggedField(dst.Type(), index, dstValue, srcValue); err != nil {
+					return reflect.Value{}, err
+				} else if ok {
+					setValueToField(ret.Field(index), v)
+					continue
 				}
+				v, err := m.elem(dst.Type().Field(index).Name, dstValue, srcValue)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				setValueToField(ret.Field(index), v)
 			} else {
 				dstValue := getValueFromField(dst.Field(index))
 				setValueToField(ret.Field(index), dstValue)
@@ -209,7 +281,13 @@ func (m *merger) mergeStruct(dst, src reflect.Value) (reflect.Value, error) {
 		for index, length := 0, dst.NumField(); index < length; index++ {
 			dstValue := getValueFromField(dst.Field(index))
 			srcValue := getValueFromField(src.Field(index))
-			v, err := m.merge(dstValue, srcValue, m.structResolver)
+			if v, ok, err := m.mergeTaggedField(dst.Type(), index, dstValue, srcValue); err != nil {
+				return reflect.Value{}, err
+			} else if ok {
+				setValueToField(ret.Field(index), v)
+				continue
+			}
+			v, err := m.mergeAt(dst.Type().Field(index).Name, dstValue, srcValue, m.structResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -225,7 +303,13 @@ func (m *merger) mergeStruct(dst, src reflect.Value) (reflect.Value, error) {
 			if isExported(dst, index) {
 				dstValue := getValueFromField(dst.Field(index))
 				srcValue := getValueFromField(src.Field(index))
-				v, err := m.merge(dstValue, srcValue, m.structResolver)
+				if v, ok, err := m.mergeTaggedField(dst.Type(), index, dstValue, srcValue); err != nil {
+					return reflect.Value{}, err
+				} else if ok {
+					setValueToField(ret.Field(index), v)
+					continue
+				}
+				v, err := m.mergeAt(dst.Type().Field(index).Name, dstValue, srcValue, m.structResolver)
 				if err != nil {
 					return reflect.Value{}, err
 				}
@@ -284,11 +368,11 @@ func (m *merger) mergeSlice(dst, src reflect.Value) (reflect.Value, error) {
 				return reflect.Value{}, err
 			}
 
-			if m.conditions.Check(dstElem, srcElem) {
-				ret = reflect.Append(ret, makeDeepPointer(srcElem, depth))
-			} else {
-				ret = reflect.Append(ret, makeDeepPointer(dstElem, depth))
+			v, err := m.elem(index, dstElem, srcElem)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			ret = reflect.Append(ret, makeDeepPointer(v, depth))
 		}
 
 	case SliceStrategyReplaceElemDynamic:
@@ -312,11 +396,11 @@ func (m *merger) mergeSlice(dst, src reflect.Value) (reflect.Value, error) {
 				return reflect.Value{}, err
 			}
 
-			if m.conditions.Check(dstElem, srcElem) {
-				ret = reflect.Append(ret, makeDeepPointer(srcElem, depth))
-			} else {
-				ret = reflect.Append(ret, makeDeepPointer(dstElem, depth))
+			v, err := m.elem(index, dstElem, srcElem)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			ret = reflect.Append(ret, makeDeepPointer(v, depth))
 		}
 
 	case SliceStrategyReplaceDeep:
@@ -332,7 +416,7 @@ func (m *merger) mergeSlice(dst, src reflect.Value) (reflect.Value, error) {
 			if index < src.Len() {
 				srcElem = src.Index(index)
 			}
-			v, err := m.merge(dstElem, srcElem, m.sliceResolver)
+			v, err := m.mergeAt(index, dstElem, srcElem, m.sliceResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -352,7 +436,7 @@ func (m *merger) mergeSlice(dst, src reflect.Value) (reflect.Value, error) {
 			if index < src.Len() {
 				srcElem = src.Index(index)
 			}
-			v, err := m.merge(dstElem, srcElem, m.sliceResolver)
+			v, err := m.mergeAt(index, dstElem, srcElem, m.sliceResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -411,13 +495,11 @@ func (m *merger) mergeChan(dst, src reflect.Value) (reflect.Value, error) {
 				return reflect.Value{}, err
 			}
 
-			if m.conditions.Check(dstElem, srcElem) {
-				retSlice = reflect.Append(retSlice,
-					makeDeepPointer(srcElem, depth))
-			} else {
-				retSlice = reflect.Append(retSlice,
-					makeDeepPointer(dstElem, depth))
+			v, err := m.elem(index, dstElem, srcElem)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			retSlice = reflect.Append(retSlice, makeDeepPointer(v, depth))
 		}
 
 		ret = sliceToChan(retSlice)
@@ -447,13 +529,11 @@ func (m *merger) mergeChan(dst, src reflect.Value) (reflect.Value, error) {
 				return reflect.Value{}, err
 			}
 
-			if m.conditions.Check(dstElem, srcElem) {
-				retSlice = reflect.Append(retSlice,
-					makeDeepPointer(srcElem, depth))
-			} else {
-				retSlice = reflect.Append(retSlice,
-					makeDeepPointer(dstElem, depth))
+			v, err := m.elem(index, dstElem, srcElem)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			retSlice = reflect.Append(retSlice, makeDeepPointer(v, depth))
 		}
 
 		ret = sliceToChan(retSlice)
@@ -475,7 +555,7 @@ func (m *merger) mergeChan(dst, src reflect.Value) (reflect.Value, error) {
 				srcElem = srcSlice.Index(index)
 			}
 
-			v, err := m.merge(dstElem, srcElem, m.chanResolver)
+			v, err := m.mergeAt(index, dstElem, srcElem, m.chanResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -502,7 +582,7 @@ func (m *merger) mergeChan(dst, src reflect.Value) (reflect.Value, error) {
 				srcElem = srcSlice.Index(index)
 			}
 
-			v, err := m.merge(dstElem, srcElem, m.chanResolver)
+			v, err := m.mergeAt(index, dstElem, srcElem, m.chanResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -532,57 +612,57 @@ func (m *merger) mergeMap(dst, src reflect.Value) (reflect.Value, error) {
 	case MapStrategyReplace:
 		ret = makeEmptyValue(dst)
 
-		for _, key := range src.MapKeys() {
+		for _, key := range m.mapKeys(src) {
 			ret.SetMapIndex(key, src.MapIndex(key))
 		}
 
 	case MapStrategyReplaceElem:
 		ret = makeEmptyValue(dst)
 
-		for _, key := range dst.MapKeys() {
+		for _, key := range m.mapKeys(dst) {
 			srcValue := src.MapIndex(key)
 			dstValue := dst.MapIndex(key)
-			if m.conditions.Check(dstValue, srcValue) {
-				ret.SetMapIndex(key, srcValue)
-			} else {
-				ret.SetMapIndex(key, dstValue)
+			v, err := m.elem(key, dstValue, srcValue)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			ret.SetMapIndex(key, v)
 		}
 
 	case MapStrategyReplaceElemDynamic:
 		ret = makeEmptyValue(dst)
 
-		for _, key := range dst.MapKeys() {
+		for _, key := range m.mapKeys(dst) {
 			srcValue := src.MapIndex(key)
 			dstValue := dst.MapIndex(key)
-			if m.conditions.Check(dstValue, srcValue) {
-				ret.SetMapIndex(key, srcValue)
-			} else {
-				ret.SetMapIndex(key, dstValue)
+			v, err := m.elem(key, dstValue, srcValue)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			ret.SetMapIndex(key, v)
 		}
 
-		for _, key := range src.MapKeys() {
+		for _, key := range m.mapKeys(src) {
 			if ret.MapIndex(key).IsValid() {
 				continue
 			}
 
 			srcValue := src.MapIndex(key)
 			dstValue := dst.MapIndex(key)
-			if m.conditions.Check(dstValue, srcValue) {
-				ret.SetMapIndex(key, srcValue)
-			} else {
-				ret.SetMapIndex(key, dstValue)
+			v, err := m.elem(key, dstValue, srcValue)
+			if err != nil {
+				return reflect.Value{}, err
 			}
+			ret.SetMapIndex(key, v)
 		}
 
 	case MapStrategyReplaceDeep:
 		ret = makeEmptyValue(dst)
 
-		for _, key := range dst.MapKeys() {
+		for _, key := range m.mapKeys(dst) {
 			srcValue := src.MapIndex(key)
 			dstValue := dst.MapIndex(key)
-			v, err := m.merge(dstValue, srcValue, m.mapResolver)
+			v, err := m.mergeAt(key, dstValue, srcValue, m.mapResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -592,24 +672,24 @@ func (m *merger) mergeMap(dst, src reflect.Value) (reflect.Value, error) {
 	case MapStrategyReplaceDeepDynamic:
 		ret = makeEmptyValue(dst)
 
-		for _, key := range dst.MapKeys() {
+		for _, key := range m.mapKeys(dst) {
 			srcValue := src.MapIndex(key)
 			dstValue := dst.MapIndex(key)
-			v, err := m.merge(dstValue, srcValue, m.mapResolver)
+			v, err := m.mergeAt(key, dstValue, srcValue, m.mapResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
 			ret.SetMapIndex(key, v)
 		}
 
-		for _, key := range src.MapKeys() {
+		for _, key := range m.mapKeys(src) {
 			if ret.MapIndex(key).IsValid() {
 				continue
 			}
 
 			srcValue := src.MapIndex(key)
 			dstValue := dst.MapIndex(key)
-			v, err := m.merge(dstValue, srcValue, m.mapResolver)
+			v, err := m.mergeAt(key, dstValue, srcValue, m.mapResolver)
 			if err != nil {
 				return reflect.Value{}, err
 			}
@@ -1,6 +1,11 @@
 package merge_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
 	"testing"
 
 	"github.com/cloudlibraries/merge"
@@ -387,3 +392,174 @@ func TestMap(t *testing.T) {
 			merge.WithMapStrategy(merge.MapStrategyReplaceDeepDynamic),
 		).(mimii))
 }
+
+// expiringContext is done after its Err method was called n times.
+type expiringContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *expiringContext) Err() error {
+	if ctx.n--; ctx.n < 0 {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func TestMergeContext(t *testing.T) {
+	type mii = map[int]int
+	type inner struct{ Values []mii }
+	type outer struct{ Inner inner }
+
+	dst := outer{Inner: inner{Values: make([]mii, 100)}}
+	src := outer{Inner: inner{Values: make([]mii, 100)}}
+	for i := range src.Inner.Values {
+		src.Inner.Values[i] = mii{i: i}
+	}
+	opts := []merge.Option{
+		merge.WithStructStrategy(merge.StructStrategyReplaceDeep),
+		merge.WithSliceStrategy(merge.SliceStrategyReplaceDeep),
+		merge.WithMapStrategy(merge.MapStrategyReplaceDeepDynamic),
+	}
+
+	v, err := merge.MergeContext(context.Background(), dst, src, opts...)
+	assert.NoError(t, err)
+	assert.Equal(t, src, v)
+
+	_, err = merge.MergeContext(&expiringContext{Context: context.Background(), n: 2}, dst, src, opts...)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var pathErr *merge.PathError
+	if assert.True(t, errors.As(err, &pathErr)) {
+		assert.Equal(t, "Inner.Values.62.62", pathErr.Path)
+	}
+	assert.Nil(t, dst.Inner.Values[0], "dst must be left untouched")
+}
+
+func TestWithLogger(t *testing.T) {
+	type config struct {
+		Name string
+		Port int
+	}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	v, err := merge.Merge(config{Name: "web", Port: 80}, config{Port: 8080},
+		merge.WithStructStrategy(merge.StructStrategyReplaceElem),
+		merge.WithCondition(merge.ConditionSrcIsNotZero),
+		merge.WithLogger(logger))
+	assert.NoError(t, err)
+	assert.Equal(t, config{Name: "web", Port: 8080}, v)
+
+	log := buf.String()
+	assert.Contains(t, log, "path=\"\" kind=struct action=merge conditions=true strategy=ReplaceElem")
+	assert.Contains(t, log, "path=Name kind=string action=keep conditions=false")
+	assert.Contains(t, log, "path=Port kind=int action=replace conditions=true")
+}
+
+func TestWithDeterministicOrder(t *testing.T) {
+	src := map[string]int{"d": 4, "b": 2, "e": 5, "a": 1, "c": 3}
+	for i := 0; i < 10; i++ {
+		var seen []int
+		v, err := merge.Merge(map[string]int{}, src,
+			merge.WithMapStrategy(merge.MapStrategyReplaceElemDynamic),
+			merge.WithDeterministicOrder(),
+			merge.WithCondition(func(dst, src reflect.Value) bool {
+				if src.Kind() == reflect.Int {
+					seen = append(seen, int(src.Int()))
+				}
+				return true
+			}))
+		assert.NoError(t, err)
+		assert.Equal(t, src, v)
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, seen)
+	}
+}
+
+func TestWithDeterministicOrderPointerKeys(t *testing.T) {
+	src := make(map[*int]int)
+	for i := 5; i > 0; i-- {
+		n := i
+		src[&n] = i
+	}
+	var seen []int
+	_, err := merge.Merge(map[*int]int{}, src,
+		merge.WithMapStrategy(merge.MapStrategyReplaceElemDynamic),
+		merge.WithDeterministicOrder(),
+		merge.WithCondition(func(dst, src reflect.Value) bool {
+			if src.Kind() == reflect.Int {
+				seen = append(seen, int(src.Int()))
+			}
+			return true
+		}))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, seen)
+}
+
+func TestStringLeafStrategies(t *testing.T) {
+	type env struct {
+		Path     string `merge:"leaf=prepend,sep=:"`
+		JavaOpts string `merge:"leaf=concat,sep=' '"`
+		Image    string `merge:"leaf=template,template={{.dst}}:{{.src}}"`
+		Selector string
+		Name     string
+	}
+	dst := env{Path: "/usr/bin:/bin", JavaOpts: "-Xmx1g", Image: "nginx", Selector: "app=web", Name: "web"}
+	src := env{Path: "/opt/bin", JavaOpts: "-Dfoo=bar", Image: "1.25", Selector: "app=web"}
+
+	v, err := merge.Merge(dst, src,
+		merge.WithStructStrategy(merge.StructStrategyReplaceDeep),
+		merge.WithCondition(merge.ConditionSrcIsNotZero),
+		merge.WithLeafStrategy(merge.AppendIfMissing(","), "Selector"))
+	assert.NoError(t, err)
+	assert.Equal(t, env{
+		Path:     "/opt/bin:/usr/bin:/bin",
+		JavaOpts: "-Xmx1g -Dfoo=bar",
+		Image:    "nginx:1.25",
+		Selector: "app=web",
+		Name:     "web",
+	}, v)
+
+	v, err = merge.Merge(map[string]string{"selector": "app=web"}, map[string]string{"selector": "env=prod"},
+		merge.WithMapStrategy(merge.MapStrategyReplaceDeepDynamic),
+		merge.WithLeafStrategy(merge.Template("{{.dst}},{{.src}}"), "selector"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"selector": "app=web,env=prod"}, v)
+
+	_, err = merge.Merge(map[string]int{"n": 1}, map[string]int{"n": 2},
+		merge.WithMapStrategy(merge.MapStrategyReplaceDeep),
+		merge.WithLeafStrategy(merge.Concat(","), "n"))
+	assert.ErrorIs(t, err, merge.ErrNotString)
+	var pathErr *merge.PathError
+	if assert.True(t, errors.As(err, &pathErr)) {
+		assert.Equal(t, "n", pathErr.Path)
+	}
+}
+
+func TestStringLeafStrategiesReplaceElem(t *testing.T) {
+	type labels struct {
+		Selector string `merge:"leaf=template,template='{{.dst}},{{.src}}'"`
+		Hosts    string `merge:"leaf=append,sep=','"`
+		Owner    string `merge:"immutable"`
+		Name     string
+	}
+	dst := labels{Selector: "app=web", Hosts: "a,b", Owner: "ops", Name: "web"}
+	src := labels{Selector: "env=prod", Hosts: "c", Owner: "dev", Name: "api"}
+
+	v, err := merge.Merge(dst, src,
+		merge.WithStructStrategy(merge.StructStrategyReplaceElem),
+		merge.WithLeafStrategy(merge.Concat("-"), "Name"))
+	assert.NoError(t, err)
+	assert.Equal(t, labels{Selector: "app=web,env=prod", Hosts: "a,b,c", Owner: "dev", Name: "web-api"}, v)
+
+	v, err = merge.Merge(map[string]string{"selector": "app=web"}, map[string]string{"selector": "env=prod"},
+		merge.WithMapStrategy(merge.MapStrategyReplaceElem),
+		merge.WithLeafStrategy(merge.Template("{{.dst}},{{.src}}"), "selector"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"selector": "app=web,env=prod"}, v)
+
+	v, err = merge.Merge([]string{"a", "b"}, []string{"c", "d"},
+		merge.WithSliceStrategy(merge.SliceStrategyReplaceElem),
+		merge.WithLeafStrategy(merge.Concat("+"), "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "b+d"}, v)
+}
@@ -1,5 +1,7 @@
 package merge
 
+import "log/slog"
+
 type Range int
 
 const (
@@ -45,6 +47,10 @@ type Options struct {
 	sliceStrategy  SliceStrategy
 	chanStrategy   ChanStrategy
 	mapStrategy    MapStrategy
+
+	logger             *slog.Logger
+	deterministicOrder bool
+	leafPaths          map[string]LeafStrategy
 }
 
 var optionsDefault = Options{
@@ -0,0 +1,158 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// WithDeterministicOrder makes Merge walk map entries in ascending key order instead
+// of the random map order, so that errors, log records and conditions come in the
+// same order on every run.
+// Pointer keys are ordered by the value they point to; only channel keys and pointers
+// to equal values are left in an order that depends on their addresses.
+func WithDeterministicOrder() Option {
+	return func(config *Options) {
+		config.deterministicOrder = true
+	}
+}
+
+// mapKeys returns the keys of v, sorted with WithDeterministicOrder.
+func (m *merger) mapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	if m.deterministicOrder {
+		sort.Slice(keys, func(i, j int) bool {
+			return compareKeys(keys[i], keys[j]) < 0
+		})
+	}
+	return keys
+}
+
+// The key comparison below, from maxKeyPointers to compareFloats, is a copy of
+// my/merge/order.go, its source: change them together.
+
+// maxKeyPointers is the number of pointers compareKeys follows before it falls back to
+// comparing their addresses, which ends the comparison of cyclic keys.
+const maxKeyPointers = 32
+
+// compareKeys orders map keys of any comparable kind: nil first, then keys of
+// different types by kind and type name, numbers by value, false before true,
+// strings, arrays and structs lexicographically, pointers by the value they point
+// to and channels by address. Pointers to equal values are ordered by address, as
+// are pointers found more than maxKeyPointers deep, so their order may change
+// from one run to the next.
+func compareKeys(a, b reflect.Value) int {
+	return comparePointedKeys(a, b, 0)
+}
+
+// comparePointedKeys is compareKeys, pointers having been followed depth times.
+func comparePointedKeys(a, b reflect.Value, depth int) int {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	switch {
+	case !a.IsValid() || !b.IsValid():
+		return compareBools(a.IsValid(), b.IsValid())
+	case a.Type() != b.Type():
+		if a.Kind() != b.Kind() {
+			return compareInts(int64(a.Kind()), int64(b.Kind()))
+		}
+		return strings.Compare(a.Type().String(), b.Type().String())
+	}
+	switch a.Kind() {
+	case reflect.Bool:
+		return compareBools(a.Bool(), b.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareInts(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return compareUints(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareFloats(a.Float(), b.Float())
+	case reflect.Complex64, reflect.Complex128:
+		if c := compareFloats(real(a.Complex()), real(b.Complex())); c != 0 {
+			return c
+		}
+		return compareFloats(imag(a.Complex()), imag(b.Complex()))
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Ptr:
+		if a.Pointer() == b.Pointer() {
+			return 0
+		}
+		if a.IsNil() || b.IsNil() {
+			return compareBools(!a.IsNil(), !b.IsNil())
+		}
+		if depth < maxKeyPointers {
+			if c := comparePointedKeys(a.Elem(), b.Elem(), depth+1); c != 0 {
+				return c
+			}
+		}
+		return compareUints(uint64(a.Pointer()), uint64(b.Pointer()))
+	case reflect.Chan, reflect.UnsafePointer:
+		return compareUints(uint64(a.Pointer()), uint64(b.Pointer()))
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := comparePointedKeys(a.Index(i), b.Index(i), depth); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := comparePointedKeys(a.Field(i), b.Field(i), depth); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareBools(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareFloats puts NaN before every other number.
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b || a != a && b == b:
+		return -1
+	case a > b || a == a && b != b:
+		return 1
+	default:
+		return 0
+	}
+}
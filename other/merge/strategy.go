@@ -128,3 +128,17 @@ var mapStrategyNames = map[MapStrategy]string{
 	MapStrategyReplaceDeep:        "ReplaceDeep",
 	MapStrategyReplaceDeepDynamic: "ReplaceDeepDynamic",
 }
+
+func (s ChanStrategy) String() string {
+	if v, ok := chanStrategyNames[s]; ok {
+		return v
+	}
+	return fmt.Errorf("%w: %d", ErrInvalidStrategy, s).Error()
+}
+
+func (s MapStrategy) String() string {
+	if v, ok := mapStrategyNames[s]; ok {
+		return v
+	}
+	return fmt.Errorf("%w: %d", ErrInvalidStrategy, s).Error()
+}